@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"github.com/suhailgupta03/thunderbyte/database/dbtest"
+	"github.com/zerodha/logf"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+// newTestAuthenticator returns an authenticator with the defaults applied
+// by NewModule
+func newTestAuthenticator(cfg Config) *authenticator {
+	if cfg.JWTSecret == "" {
+		cfg.JWTSecret = "secret"
+	}
+	if cfg.HashParams == (HashParams{}) {
+		cfg.HashParams = testHashParams
+	}
+	return &authenticator{cfg: cfg.withDefaults()}
+}
+
+// newTestContext returns the context of a request without any of the
+// services of the app
+func newTestContext() common.AppContext {
+	l := logf.New(logf.Opts{Level: logf.FatalLevel})
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+	return common.AppContext{HTTPServerContext: c, Logger: &l}
+}
+
+// services are the database and Redis of the tests needing them
+type services struct {
+	db    *database.DBConfig
+	redis *cache.Redis
+	mr    *miniredis.Miniredis
+}
+
+// newServices connects to the test database, skipping the test when there
+// is none, and starts an in-memory Redis
+func newServices(t *testing.T) *services {
+	t.Helper()
+	db := dbtest.Connect(t)
+	mr := miniredis.RunT(t)
+	r := cache.New(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { _ = r.Close() })
+	return &services{db: db, redis: r, mr: mr}
+}
+
+// context returns the context of a new request with the services
+func (s *services) context() common.AppContext {
+	ctx := newTestContext()
+	ctx.DBConfig = s.db
+	ctx.Redis = s.redis
+	return ctx
+}
+
+var userSeq atomic.Int64
+
+// signup creates a user with a unique name and returns its session
+func (s *services) signup(t *testing.T, a *authenticator, password string) *Session {
+	t.Helper()
+	name := fmt.Sprintf("user_%d_%d", time.Now().UnixNano(), userSeq.Add(1))
+	session, herr := a.signup(s.context(), SignupRequest{Username: name, Password: password})
+	if herr != nil {
+		t.Fatalf("signup: %v", herr)
+	}
+	return session
+}
+
+func statusOf(herr *common.HTTPError) int {
+	if herr == nil {
+		return 0
+	}
+	return herr.Code
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/otp"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultBackupCodeCount = 10
+	backupCodeLength       = 10
+	// backupCodeChars It leaves out the characters easily mistaken for
+	// one another
+	backupCodeChars = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+type BackupCodes struct {
+	// Codes They are shown once. Only their hashes are stored
+	Codes []string `json:"codes"`
+}
+
+type BackupCodesRemaining struct {
+	Remaining int `json:"remaining"`
+}
+
+// normalizeBackupCode It accepts codes typed with dashes, spaces or in
+// upper case
+func normalizeBackupCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// backupCodeKey It keys the backup code hashes. It is derived from the MFA
+// encryption key so that a leaked table cannot be brute forced offline
+func (a *authenticator) backupCodeKey() []byte {
+	mac := hmac.New(sha256.New, a.cfg.MFA.EncryptionKey)
+	mac.Write([]byte("thunderbyte-backup-codes"))
+	return mac.Sum(nil)
+}
+
+// hashBackupCode It returns the value stored in auth_backup_codes, an
+// HMAC-SHA256 of the normalized code
+func (a *authenticator) hashBackupCode(code string) string {
+	mac := hmac.New(sha256.New, a.backupCodeKey())
+	mac.Write([]byte(normalizeBackupCode(code)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newBackupCodes It replaces the backup codes of the user by a new set
+func (a *authenticator) newBackupCodes(ctx common.AppContext, userId int64) (*BackupCodes, error) {
+	c := ctx.Context()
+	q := ctx.DBConfig.GetDefaultQueries()
+	tx, err := ctx.DBConfig.GetDB().BeginTxx(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.StmtxContext(c, q.DeleteBackupCodes).ExecContext(c, userId); err != nil {
+		return nil, err
+	}
+	insert := tx.StmtxContext(c, q.CreateBackupCode)
+	codes := make([]string, 0, a.cfg.MFA.BackupCodes)
+	for len(codes) < a.cfg.MFA.BackupCodes {
+		code, err := otp.GenerateRandomString(backupCodeLength, backupCodeChars)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := insert.ExecContext(c, userId, a.hashBackupCode(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:backupCodeLength/2]+"-"+code[backupCodeLength/2:])
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &BackupCodes{Codes: codes}, nil
+}
+
+// consumeBackupCode It marks the code as used. It fails if the code is
+// unknown or was already used
+func (a *authenticator) consumeBackupCode(ctx common.AppContext, userId int64, code string) *common.HTTPError {
+	var id int64
+	err := ctx.DBConfig.GetDefaultQueries().ConsumeBackupCode.GetContext(ctx.Context(), &id, userId, a.hashBackupCode(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &common.HTTPError{Code: http.StatusBadRequest, Message: "Incorrect backup code"}
+	}
+	if err != nil {
+		return internalError(ctx, "Failed to verify backup code", err)
+	}
+	ctx.Logger.Info("Backup code used", "userId", userId)
+	return nil
+}
+
+// regenerateBackupCodes It invalidates the backup codes of the
+// authenticated user and returns a new set
+func (a *authenticator) regenerateBackupCodes(ctx common.AppContext, _ empty) (*BackupCodes, *common.HTTPError) {
+	userId, herr := userIdFromClaims(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	row, err := a.totpSecret(ctx, userId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to generate backup codes", err)
+	}
+	if row == nil || !row.ConfirmedAt.Valid {
+		return nil, &common.HTTPError{Code: http.StatusConflict, Message: "TOTP is not enabled"}
+	}
+	codes, err := a.newBackupCodes(ctx, userId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to generate backup codes", err)
+	}
+	return codes, nil
+}
+
+// countBackupCodes It returns the number of unused backup codes of the
+// authenticated user
+func (a *authenticator) countBackupCodes(ctx common.AppContext, _ empty) (*BackupCodesRemaining, *common.HTTPError) {
+	userId, herr := userIdFromClaims(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	var out BackupCodesRemaining
+	if err := ctx.DBConfig.GetDefaultQueries().CountBackupCodes.GetContext(ctx.Context(), &out.Remaining, userId); err != nil {
+		return nil, internalError(ctx, "Failed to count backup codes", err)
+	}
+	return &out, nil
+}
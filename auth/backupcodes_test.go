@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBackupCodeHash(t *testing.T) {
+	a := newTestAuthenticator(Config{MFA: &MFAConfig{EncryptionKey: testEncryptionKey}})
+	if a.hashBackupCode("abcd-efgh") != a.hashBackupCode(" ABCD EFGH ") {
+		t.Error("the code is not normalized before hashing")
+	}
+	other := newTestAuthenticator(Config{MFA: &MFAConfig{EncryptionKey: []byte("fedcba9876543210")}})
+	if a.hashBackupCode("abcd-efgh") == other.hashBackupCode("abcd-efgh") {
+		t.Error("the hash does not depend on the encryption key")
+	}
+}
+
+func TestBackupCodeSingleUse(t *testing.T) {
+	s := newServices(t)
+	a := newTestAuthenticator(Config{MFA: &MFAConfig{EncryptionKey: testEncryptionKey, BackupCodes: 3}})
+	user := s.signup(t, a, "correct horse").User
+	codes, err := a.newBackupCodes(s.context(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes.Codes) != 3 {
+		t.Fatalf("got %d codes, want 3", len(codes.Codes))
+	}
+
+	// Concurrent logins with the same code: exactly one succeeds.
+	const n = 20
+	var wg sync.WaitGroup
+	statuses := make(chan int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- statusOf(a.consumeBackupCode(s.context(), user.ID, codes.Codes[0]))
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[0] != 1 || counts[http.StatusBadRequest] != n-1 {
+		t.Errorf("got statuses %v, want one success and %d rejections", counts, n-1)
+	}
+
+	// The other codes are still valid, typed in any case.
+	if herr := a.consumeBackupCode(s.context(), user.ID, strings.ToUpper(codes.Codes[1])); herr != nil {
+		t.Errorf("second code: %v", herr)
+	}
+	other := s.signup(t, a, "correct horse").User
+	if herr := a.consumeBackupCode(s.context(), other.ID, codes.Codes[2]); statusOf(herr) != http.StatusBadRequest {
+		t.Errorf("the code of another user got %v, want a 400", herr)
+	}
+
+	// Regenerating invalidates the unused codes.
+	if _, err := a.newBackupCodes(s.context(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if herr := a.consumeBackupCode(s.context(), user.ID, codes.Codes[2]); statusOf(herr) != http.StatusBadRequest {
+		t.Errorf("a code of the previous set got %v, want a 400", herr)
+	}
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// encrypt It seals the plaintext with AES-GCM. The additional data binds
+// the ciphertext to its owner so that it cannot be copied to another row
+func encrypt(key []byte, plaintext, additionalData string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(additionalData))
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt It opens a value sealed by encrypt
+func decrypt(key []byte, ciphertext, additionalData string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"github.com/suhailgupta03/thunderbyte/otp"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// uniqueViolation It is the Postgres error code for a duplicate key
+	uniqueViolation = "23505"
+	// emailUniqueConstraint It is the unique index on auth_users.email
+	emailUniqueConstraint = "auth_users_email_unique"
+)
+
+type authenticator struct {
+	cfg Config
+
+	// otp It is the OTP service built by init when the module does not
+	// use the one of the app
+	otp *otp.Service
+
+	// sweepMu It guards lastSweep, when the expired sessions were last deleted
+	sweepMu   sync.Mutex
+	lastSweep time.Time
+
+	// mails It tracks the password reset codes being sent in the background
+	mails sync.WaitGroup
+}
+
+type Credentials struct {
+	Username string `json:"username" validate:"required,min=3,max=64"`
+	Password string `json:"password" validate:"required,min=8,max=128"`
+}
+
+type SignupRequest struct {
+	Username string `json:"username" validate:"required,min=3,max=64"`
+	Password string `json:"password" validate:"required,min=8,max=128"`
+	// Email It is optional. When set and OTPConfig is configured a
+	// verification code is e-mailed to it
+	Email string `json:"email" validate:"omitempty,email,max=254"`
+}
+
+type Profile struct {
+	ID            int64  `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"emailVerified"`
+}
+
+func profileOf(p *database.AuthProfile) *Profile {
+	return &Profile{
+		ID:            p.UserId,
+		Username:      p.Username,
+		Email:         p.Email.String,
+		EmailVerified: p.EmailVerifiedAt.Valid,
+	}
+}
+
+type Token struct {
+	AccessToken      string    `json:"accessToken"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+}
+
+type Session struct {
+	User  Profile `json:"user"`
+	Token *Token  `json:"token"`
+	// MFA It is set instead of Token when the login has to be completed
+	// with a second factor
+	MFA *MFAChallenge `json:"mfa,omitempty"`
+}
+
+type empty struct{}
+
+var errInvalidCredentials = &common.HTTPError{Code: http.StatusUnauthorized, Message: "Invalid username or password"}
+
+func internalError(ctx common.AppContext, msg string, err error) *common.HTTPError {
+	ctx.Logger.Error(msg, "error", err)
+	return &common.HTTPError{Code: http.StatusInternalServerError, Message: msg}
+}
+
+// signup It creates the user along with its password and starts a session
+func (a *authenticator) signup(ctx common.AppContext, req SignupRequest) (*Session, *common.HTTPError) {
+	hash, err := HashPassword(req.Password, a.cfg.HashParams)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to create user", err)
+	}
+
+	db := ctx.DBConfig.GetDB()
+	q := ctx.DBConfig.GetDefaultQueries()
+
+	tx, err := db.BeginTxx(ctx.Context(), nil)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to create user", err)
+	}
+	defer tx.Rollback()
+
+	var userId int64
+	if err := tx.StmtxContext(ctx.Context(), q.CreateAuthProfile).GetContext(ctx.Context(), &userId, req.Username, req.Email); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			if pqErr.Constraint == emailUniqueConstraint {
+				return nil, &common.HTTPError{Code: http.StatusConflict, Message: "E-mail address is already registered"}
+			}
+			return nil, &common.HTTPError{Code: http.StatusConflict, Message: "Username is already taken"}
+		}
+		return nil, internalError(ctx, "Failed to create user", err)
+	}
+	if _, err := tx.StmtxContext(ctx.Context(), q.CreatePassword).ExecContext(ctx.Context(), hash, userId); err != nil {
+		return nil, internalError(ctx, "Failed to create user", err)
+	}
+	sessionId := uuid.NewString()
+	refresh, err := a.createRefreshToken(ctx.Context(), tx.StmtxContext(ctx.Context(), q.CreateAuthSession), sessionId, userId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to create user", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, internalError(ctx, "Failed to create user", err)
+	}
+
+	token, err := a.issueToken(ctx, userId, sessionId, refresh)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to issue token", err)
+	}
+	ctx.Logger.Info("User signed up", "userId", userId)
+	if req.Email != "" && a.cfg.OTP != nil {
+		// The account is usable without a verified address, a failure is
+		// only logged and the code can be requested again
+		if herr := a.sendOTP(ctx, EmailVerificationNamespace, userId, req.Email); herr != nil {
+			ctx.Logger.Warn("Failed to send e-mail verification", "userId", userId, "error", herr.Message)
+		}
+	}
+	return &Session{User: Profile{ID: userId, Username: req.Username, Email: req.Email}, Token: token}, nil
+}
+
+// login It verifies the credentials and starts a session, or returns a
+// challenge when the user has a second factor. Passwords stored in
+// plaintext or with outdated parameters are rehashed on success
+func (a *authenticator) login(ctx common.AppContext, req Credentials) (*Session, *common.HTTPError) {
+	q := ctx.DBConfig.GetDefaultQueries()
+	var user database.VerifiedUser
+	err := q.VerifyCredentials.GetContext(ctx.Context(), &user, req.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Hash anyway so that unknown usernames take as long as wrong passwords
+		_, _ = HashPassword(req.Password, a.cfg.HashParams)
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, internalError(ctx, "Failed to verify credentials", err)
+	}
+
+	ok, needsRehash, err := VerifyPassword(req.Password, user.Password, user.Hashed, a.cfg.HashParams)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to verify credentials", err)
+	}
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	if needsRehash {
+		a.rehash(ctx, user.UserId, req.Password)
+	}
+
+	profile := Profile{
+		ID:            user.UserId,
+		Username:      user.Username,
+		Email:         user.Email.String,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+	}
+	challenge, err := a.challenge(ctx, user.UserId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to verify credentials", err)
+	}
+	if challenge != nil {
+		return &Session{User: profile, MFA: challenge}, nil
+	}
+	return a.startSession(ctx, profile)
+}
+
+// rehash It replaces the stored password by a hash created with the
+// configured parameters. Failures are logged, the login goes through
+func (a *authenticator) rehash(ctx common.AppContext, userId int64, password string) {
+	hash, err := HashPassword(password, a.cfg.HashParams)
+	if err == nil {
+		_, err = ctx.DBConfig.GetDefaultQueries().UpdatePassword.ExecContext(ctx.Context(), hash, userId)
+	}
+	if err != nil {
+		ctx.Logger.Warn("Failed to rehash password", "userId", userId, "error", err)
+	}
+}
+
+// me It returns the profile of the authenticated user
+func (a *authenticator) me(ctx common.AppContext, _ empty) (*Profile, *common.HTTPError) {
+	profile, herr := a.currentProfile(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	return profileOf(profile), nil
+}
+
+// currentProfile It fetches the user identified by the `sub` claim
+func (a *authenticator) currentProfile(ctx common.AppContext) (*database.AuthProfile, *common.HTTPError) {
+	userId, herr := userIdFromClaims(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	var profile database.AuthProfile
+	err := ctx.DBConfig.GetDefaultQueries().FetchAuthProfileById.GetContext(ctx.Context(), &profile, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &common.HTTPError{Code: http.StatusNotFound, Message: "User not found"}
+	}
+	if err != nil {
+		return nil, internalError(ctx, "Failed to fetch user", err)
+	}
+	return &profile, nil
+}
+
+// userIdFromClaims It returns the user id held by the `sub` claim
+func userIdFromClaims(ctx common.AppContext) (int64, *common.HTTPError) {
+	claims, ok := common.ClaimsAs[*common.Claims](ctx)
+	if !ok {
+		return 0, &common.HTTPError{Code: http.StatusUnauthorized, Message: "Authentication required"}
+	}
+	userId, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, &common.HTTPError{Code: http.StatusUnauthorized, Message: "Token subject is not a user id"}
+	}
+	return userId, nil
+}
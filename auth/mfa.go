@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"github.com/suhailgupta03/thunderbyte/otp/totp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMFAChallengeTTL = 5 * time.Minute
+	defaultMFAMaxAttempts  = 5
+	mfaAttemptsKey         = "TB:MFA:ATTEMPTS:%s"
+	totpReplayKeyPrefix    = "TB:TOTP:USED:"
+)
+
+// MFAConfig It enables TOTP as a second factor. Users enroll an
+// authenticator app and, once confirmed, login returns a challenge to be
+// completed with a code
+type MFAConfig struct {
+	// EncryptionKey It encrypts the TOTP secrets at rest with AES-GCM and
+	// keys the backup code hashes. It must be 16, 24 or 32 bytes long
+	EncryptionKey []byte
+	// TOTP It holds the code parameters. Issuer defaults to Config.Issuer
+	TOTP totp.Config
+	// ChallengeTTL It is how long a login challenge can be completed.
+	// Defaults to 5 minutes
+	ChallengeTTL time.Duration
+	// MaxAttempts It is the number of codes accepted per challenge. Defaults to 5
+	MaxAttempts int
+	// ReplayCache It rejects codes already used. Defaults to one backed by
+	// the Redis store of the app
+	ReplayCache totp.ReplayCache
+	// BackupCodes It is the number of single use codes generated when TOTP
+	// is confirmed. Defaults to 10
+	BackupCodes int
+}
+
+func (c *MFAConfig) withDefaults(issuer string) *MFAConfig {
+	out := *c
+	if out.TOTP.Issuer == "" {
+		out.TOTP.Issuer = issuer
+	}
+	if out.ChallengeTTL == 0 {
+		out.ChallengeTTL = defaultMFAChallengeTTL
+	}
+	if out.MaxAttempts == 0 {
+		out.MaxAttempts = defaultMFAMaxAttempts
+	}
+	if out.BackupCodes == 0 {
+		out.BackupCodes = defaultBackupCodeCount
+	}
+	return &out
+}
+
+// MFAChallenge It is returned by login instead of a token when the user
+// has a second factor
+type MFAChallenge struct {
+	Token     string    `json:"mfaToken"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	// QRCode It is the PNG rendering of the URI, base64 encoded in JSON
+	QRCode []byte `json:"qrCode"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required,numeric,max=10"`
+}
+
+// MFAVerifyRequest It completes a challenge with either a TOTP code or a
+// backup code
+type MFAVerifyRequest struct {
+	MFAToken   string `json:"mfaToken" validate:"required"`
+	Code       string `json:"code" validate:"required_without=BackupCode,omitempty,numeric,max=10"`
+	BackupCode string `json:"backupCode" validate:"required_without=Code,omitempty,max=32"`
+}
+
+var errInvalidMFAChallenge = &common.HTTPError{Code: http.StatusUnauthorized, Message: "Invalid or expired MFA challenge"}
+
+// challengeKey It signs the challenge tokens. It is derived from the JWT
+// secret so that a challenge is never accepted as an access token
+func (a *authenticator) challengeKey() []byte {
+	sum := sha256.Sum256([]byte("thunderbyte-mfa:" + a.cfg.JWTSecret))
+	return sum[:]
+}
+
+// totpSecret It returns the TOTP row of the user, or nil when there is none
+func (a *authenticator) totpSecret(ctx common.AppContext, userId int64) (*database.TOTPSecret, error) {
+	var row database.TOTPSecret
+	err := ctx.DBConfig.GetDefaultQueries().FetchTOTPSecret.GetContext(ctx.Context(), &row, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// challenge It returns a login challenge when the user has a confirmed
+// second factor, nil otherwise
+func (a *authenticator) challenge(ctx common.AppContext, userId int64) (*MFAChallenge, error) {
+	if a.cfg.MFA == nil {
+		return nil, nil
+	}
+	row, err := a.totpSecret(ctx, userId)
+	if err != nil || row == nil || !row.ConfirmedAt.Valid {
+		return nil, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(a.cfg.MFA.ChallengeTTL)
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Subject:   strconv.FormatInt(userId, 10),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}).SignedString(a.challengeKey())
+	if err != nil {
+		return nil, err
+	}
+	return &MFAChallenge{Token: signed, ExpiresAt: expiresAt}, nil
+}
+
+// checkTOTP It validates the code against the secret of the user and
+// records its time step so that it cannot be used again
+func (a *authenticator) checkTOTP(ctx common.AppContext, row *database.TOTPSecret, code string) *common.HTTPError {
+	secret, err := decrypt(a.cfg.MFA.EncryptionKey, row.Secret, strconv.FormatInt(row.UserId, 10))
+	if err != nil {
+		return internalError(ctx, "Failed to read TOTP secret", err)
+	}
+	step, ok, err := totp.Validate(a.cfg.MFA.TOTP, secret, code, time.Now())
+	if err != nil {
+		return internalError(ctx, "Failed to validate code", err)
+	}
+	if !ok {
+		return &common.HTTPError{Code: http.StatusBadRequest, Message: "Incorrect code"}
+	}
+
+	cache := a.cfg.MFA.ReplayCache
+	if cache == nil {
+		if ctx.Redis == nil {
+			return internalError(ctx, "Failed to validate code", errors.New("no TOTP replay cache configured"))
+		}
+		cache = totp.NewRedisReplayCache(ctx.Redis.Client(), totpReplayKeyPrefix)
+	}
+	fresh, err := cache.Use(ctx.Context(), strconv.FormatInt(row.UserId, 10), step, totp.ReplayTTL(a.cfg.MFA.TOTP))
+	if err != nil {
+		return internalError(ctx, "Failed to validate code", err)
+	}
+	if !fresh {
+		return &common.HTTPError{Code: http.StatusBadRequest, Message: "Code has already been used"}
+	}
+	return nil
+}
+
+// enrollTOTP It generates a new secret for the authenticated user. It is
+// not required on login until confirmed with a code
+func (a *authenticator) enrollTOTP(ctx common.AppContext, _ empty) (*TOTPEnrollment, *common.HTTPError) {
+	profile, herr := a.currentProfile(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	row, err := a.totpSecret(ctx, profile.UserId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to enroll TOTP", err)
+	}
+	if row != nil && row.ConfirmedAt.Valid {
+		return nil, &common.HTTPError{Code: http.StatusConflict, Message: "TOTP is already enabled"}
+	}
+
+	secret, err := totp.GenerateSecret(0)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to enroll TOTP", err)
+	}
+	encrypted, err := encrypt(a.cfg.MFA.EncryptionKey, secret, strconv.FormatInt(profile.UserId, 10))
+	if err != nil {
+		return nil, internalError(ctx, "Failed to enroll TOTP", err)
+	}
+	if _, err := ctx.DBConfig.GetDefaultQueries().UpsertTOTPSecret.ExecContext(ctx.Context(), profile.UserId, encrypted); err != nil {
+		return nil, internalError(ctx, "Failed to enroll TOTP", err)
+	}
+
+	uri := totp.URI(a.cfg.MFA.TOTP, profile.Username, secret)
+	qr, err := totp.QRCodePNG(uri, 0)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to render QR code", err)
+	}
+	return &TOTPEnrollment{Secret: secret, URI: uri, QRCode: qr}, nil
+}
+
+// confirmTOTP It enables the enrolled secret once a code generated from it
+// is presented and returns the first set of backup codes
+func (a *authenticator) confirmTOTP(ctx common.AppContext, req TOTPCodeRequest) (*BackupCodes, *common.HTTPError) {
+	userId, herr := userIdFromClaims(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	row, err := a.totpSecret(ctx, userId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to confirm TOTP", err)
+	}
+	if row == nil {
+		return nil, &common.HTTPError{Code: http.StatusNotFound, Message: "No TOTP enrollment in progress"}
+	}
+	if row.ConfirmedAt.Valid {
+		return nil, &common.HTTPError{Code: http.StatusConflict, Message: "TOTP is already enabled"}
+	}
+	if herr := a.checkTOTP(ctx, row, req.Code); herr != nil {
+		return nil, herr
+	}
+	if _, err := ctx.DBConfig.GetDefaultQueries().ConfirmTOTP.ExecContext(ctx.Context(), userId); err != nil {
+		return nil, internalError(ctx, "Failed to confirm TOTP", err)
+	}
+	ctx.Logger.Info("TOTP enabled", "userId", userId)
+	codes, err := a.newBackupCodes(ctx, userId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to generate backup codes", err)
+	}
+	return codes, nil
+}
+
+// disableTOTP It removes the second factor. A current code is required
+func (a *authenticator) disableTOTP(ctx common.AppContext, req TOTPCodeRequest) (*empty, *common.HTTPError) {
+	userId, herr := userIdFromClaims(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	row, err := a.totpSecret(ctx, userId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to disable TOTP", err)
+	}
+	if row == nil || !row.ConfirmedAt.Valid {
+		return nil, &common.HTTPError{Code: http.StatusNotFound, Message: "TOTP is not enabled"}
+	}
+	if herr := a.checkTOTP(ctx, row, req.Code); herr != nil {
+		return nil, herr
+	}
+	q := ctx.DBConfig.GetDefaultQueries()
+	if _, err := q.DeleteTOTP.ExecContext(ctx.Context(), userId); err != nil {
+		return nil, internalError(ctx, "Failed to disable TOTP", err)
+	}
+	if _, err := q.DeleteBackupCodes.ExecContext(ctx.Context(), userId); err != nil {
+		return nil, internalError(ctx, "Failed to delete backup codes", err)
+	}
+	ctx.Logger.Info("TOTP disabled", "userId", userId)
+	return &empty{}, nil
+}
+
+// verifyMFA It completes a login challenge with a TOTP code or a backup
+// code and starts the session
+func (a *authenticator) verifyMFA(ctx common.AppContext, req MFAVerifyRequest) (*Session, *common.HTTPError) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(req.MFAToken, &claims, func(*jwt.Token) (interface{}, error) {
+		return a.challengeKey(), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, errInvalidMFAChallenge
+	}
+	userId, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, errInvalidMFAChallenge
+	}
+	if herr := a.countMFAAttempt(ctx, claims.ID); herr != nil {
+		return nil, herr
+	}
+
+	row, err := a.totpSecret(ctx, userId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to verify code", err)
+	}
+	if row == nil || !row.ConfirmedAt.Valid {
+		return nil, errInvalidMFAChallenge
+	}
+	var herr *common.HTTPError
+	if req.Code != "" {
+		herr = a.checkTOTP(ctx, row, req.Code)
+	} else {
+		herr = a.consumeBackupCode(ctx, userId, req.BackupCode)
+	}
+	if herr != nil {
+		return nil, herr
+	}
+	var profile database.AuthProfile
+	if err := ctx.DBConfig.GetDefaultQueries().FetchAuthProfileById.GetContext(ctx.Context(), &profile, userId); err != nil {
+		return nil, internalError(ctx, "Failed to fetch user", err)
+	}
+	return a.startSession(ctx, *profileOf(&profile))
+}
+
+// countMFAAttempt It limits the number of codes tried per challenge
+func (a *authenticator) countMFAAttempt(ctx common.AppContext, challengeId string) *common.HTTPError {
+	if ctx.Redis == nil {
+		return internalError(ctx, "Failed to verify code", errors.New("no MFA attempt counter configured"))
+	}
+	attempts, err := ctx.Redis.Incr(ctx.Context(), fmt.Sprintf(mfaAttemptsKey, challengeId), a.cfg.MFA.ChallengeTTL)
+	if err != nil {
+		return internalError(ctx, "Failed to verify code", err)
+	}
+	if attempts > int64(a.cfg.MFA.MaxAttempts) {
+		return &common.HTTPError{Code: http.StatusTooManyRequests, Message: "Too many attempts. Please log in again"}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"github.com/suhailgupta03/thunderbyte/otp/totp"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryReplayCache is a totp.ReplayCache keeping the last step per key
+type memoryReplayCache struct {
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+func (m *memoryReplayCache) Use(_ context.Context, key string, step int64, _ time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if last, ok := m.last[key]; ok && step <= last {
+		return false, nil
+	}
+	m.last[key] = step
+	return true, nil
+}
+
+func TestVerifyMFAFailsClosedWithoutCounter(t *testing.T) {
+	a := newTestAuthenticator(Config{MFA: &MFAConfig{EncryptionKey: testEncryptionKey}})
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ID:        "challenge",
+		Subject:   "1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}).SignedString(a.challengeKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without Redis the attempts can't be counted, so the code must not be
+	// checked at all. The context has no database either, so getting any
+	// further would panic.
+	_, herr := a.verifyMFA(newTestContext(), MFAVerifyRequest{MFAToken: token, Code: "123456"})
+	if herr == nil || herr.Code != http.StatusInternalServerError {
+		t.Fatalf("verifyMFA() = %v, want a 500", herr)
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	secret, err := totp.GenerateSecret(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const userId = 7
+	encrypted, err := encrypt(testEncryptionKey, secret, strconv.Itoa(userId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := &database.TOTPSecret{UserId: userId, Secret: encrypted, ConfirmedAt: sql.NullTime{Time: time.Now(), Valid: true}}
+	code, err := totp.Code(totp.Config{}, secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	cache := &memoryReplayCache{last: map[string]int64{}}
+	a := newTestAuthenticator(Config{MFA: &MFAConfig{EncryptionKey: testEncryptionKey, ReplayCache: cache}})
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+	}{
+		{"wrong code", wrong, http.StatusBadRequest},
+		{"valid code", code, 0},
+		{"replayed code", code, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		herr := a.checkTOTP(newTestContext(), row, tt.code)
+		if got := statusOf(herr); got != tt.wantCode {
+			t.Errorf("%s: got status %d, want %d", tt.name, got, tt.wantCode)
+		}
+	}
+
+	// Without a replay cache nor Redis a valid code is refused.
+	a = newTestAuthenticator(Config{MFA: &MFAConfig{EncryptionKey: testEncryptionKey}})
+	if got := statusOf(a.checkTOTP(newTestContext(), row, code)); got != http.StatusInternalServerError {
+		t.Errorf("without a replay cache got status %d, want 500", got)
+	}
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultModulePath = "/auth"
+	defaultTokenTTL   = 15 * time.Minute
+	defaultRefreshTTL = 30 * 24 * time.Hour
+	// tokenCookieName It is the cookie read by common.TokenLookupCookie
+	tokenCookieName = "token"
+)
+
+// Config It configures the auth module
+type Config struct {
+	// ModulePath It is where the controllers are mounted. Defaults to /auth
+	ModulePath common.RoutePath
+	// JWTSecret It signs the issued tokens with HS256. Use the same value as
+	// ControllerConfig.JWTSecret on the modules to be protected, or pass
+	// JWTConfig() to them
+	JWTSecret string
+	// TokenTTL It is the lifetime of the access tokens. Defaults to 15 minutes
+	TokenTTL time.Duration
+	// RefreshTokenTTL It is the lifetime of a refresh token. Each refresh
+	// issues a new one. Defaults to 30 days
+	RefreshTokenTTL time.Duration
+	// Issuer If set it is written to the `iss` claim and required on verification
+	Issuer string
+	// Audience If set it is written to the `aud` claim and required on verification
+	Audience string
+	// CookieDomain It is the domain of the token cookie
+	CookieDomain string
+	// CookieSecure It marks the token cookie as Secure
+	CookieSecure bool
+	// CookieSameSite Defaults to http.SameSiteLaxMode
+	CookieSameSite http.SameSite
+	// OTP If set the password reset and e-mail verification controllers
+	// are added
+	OTP *OTPConfig
+	// MFA If set users can enroll a TOTP authenticator app as a second factor
+	MFA *MFAConfig
+	// HashParams They are the argon2id parameters of new password hashes.
+	// Defaults to DefaultHashParams()
+	HashParams HashParams
+}
+
+// JWTConfig It returns the verification config matching the tokens issued
+// by the module, to be used as ControllerConfig.JWT on other modules. Tokens
+// of revoked sessions are rejected, which requires Redis
+func (cfg Config) JWTConfig() *common.JWTConfig {
+	return &common.JWTConfig{
+		SigningMethods:  []string{"HS256"},
+		Secret:          cfg.JWTSecret,
+		TokenLookup:     common.TokenLookupCookie + "," + common.TokenLookupBearer,
+		Issuer:          cfg.Issuer,
+		Audience:        cfg.Audience,
+		CheckRevocation: true,
+	}
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.ModulePath == "" {
+		cfg.ModulePath = defaultModulePath
+	}
+	if cfg.TokenTTL == 0 {
+		cfg.TokenTTL = defaultTokenTTL
+	}
+	if cfg.RefreshTokenTTL == 0 {
+		cfg.RefreshTokenTTL = defaultRefreshTTL
+	}
+	if cfg.CookieSameSite == 0 {
+		cfg.CookieSameSite = http.SameSiteLaxMode
+	}
+
+	cfg.HashParams = cfg.HashParams.withDefaults()
+	if cfg.OTP != nil {
+		cfg.OTP = cfg.OTP.withDefaults()
+	}
+	if cfg.MFA != nil {
+		cfg.MFA = cfg.MFA.withDefaults(cfg.Issuer)
+	}
+	return cfg
+}
+
+// NewModule It returns the auth module to be passed in FactoryCreate.Imports.
+// It exposes signup, login, refresh, logout, logout-all and me controllers
+// backed by the auth_users, auth_passwords and auth_sessions tables, and
+// the password reset and e-mail verification ones when OTP is set and the
+// TOTP ones when MFA is set. It requires Redis to revoke the access tokens
+// of the sessions logged out, the app fails to start without it
+func NewModule(cfg Config) *common.Module {
+	cfg = cfg.withDefaults()
+	a := &authenticator{cfg: cfg}
+	controllers := common.Controllers{
+		"signup":     {common.POST: {Handler: common.Handle(a.signup)}},
+		"login":      {common.POST: {Handler: common.Handle(a.login)}},
+		"refresh":    {common.POST: {Handler: common.Handle(a.refresh)}},
+		"logout":     {common.POST: {Handler: common.Handle(a.logout)}},
+		"logout-all": {common.POST: {Handler: common.Handle(a.logoutAll), JWT: cfg.JWTConfig()}},
+		"me":         {common.GET: {Handler: common.Handle(a.me), JWT: cfg.JWTConfig()}},
+	}
+	if cfg.OTP != nil {
+		controllers["password/forgot"] = common.HTTPMethodConfig{common.POST: {Handler: common.Handle(a.forgotPassword)}}
+		controllers["password/reset"] = common.HTTPMethodConfig{common.POST: {Handler: common.Handle(a.resetPassword)}}
+		controllers["email/verification"] = common.HTTPMethodConfig{common.POST: {Handler: common.Handle(a.sendEmailVerification), JWT: cfg.JWTConfig()}}
+		controllers["email/verify"] = common.HTTPMethodConfig{common.POST: {Handler: common.Handle(a.verifyEmail), JWT: cfg.JWTConfig()}}
+	}
+	if cfg.MFA != nil {
+		controllers["mfa/verify"] = common.HTTPMethodConfig{common.POST: {Handler: common.Handle(a.verifyMFA)}}
+		controllers["mfa/totp/enroll"] = common.HTTPMethodConfig{common.POST: {Handler: common.Handle(a.enrollTOTP), JWT: cfg.JWTConfig()}}
+		controllers["mfa/totp/confirm"] = common.HTTPMethodConfig{common.POST: {Handler: common.Handle(a.confirmTOTP), JWT: cfg.JWTConfig()}}
+		controllers["mfa/totp/disable"] = common.HTTPMethodConfig{common.POST: {Handler: common.Handle(a.disableTOTP), JWT: cfg.JWTConfig()}}
+		controllers["mfa/backup-codes"] = common.HTTPMethodConfig{
+			common.GET:  {Handler: common.Handle(a.countBackupCodes), JWT: cfg.JWTConfig()},
+			common.POST: {Handler: common.Handle(a.regenerateBackupCodes), JWT: cfg.JWTConfig()},
+		}
+	}
+	return &common.Module{
+		Init: a.init,
+		ControllerConfig: &common.ControllerConfig{
+			ModulePath:  cfg.ModulePath,
+			Controllers: controllers,
+		},
+	}
+}
+
+// init It checks the config and the services required by the module when
+// the app starts
+func (a *authenticator) init(p common.InitModuleParams) error {
+	if a.cfg.JWTSecret == "" {
+		return errors.New("auth: JWTSecret is required to sign the tokens")
+	}
+	if a.cfg.MFA != nil {
+		// AES-128, AES-192 or AES-256
+		switch n := len(a.cfg.MFA.EncryptionKey); n {
+		case 16, 24, 32:
+		default:
+			return fmt.Errorf("auth: MFA.EncryptionKey must be 16, 24 or 32 bytes long, got %d", n)
+		}
+	}
+	if p.Redis == nil {
+		return errors.New("auth: Redis is required to revoke sessions, configure it on the app")
+	}
+	if a.cfg.OTP != nil {
+		if err := a.initOTP(p); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := cache.New(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { _ = r.Close() })
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		redis   *cache.Redis
+		wantErr bool
+	}{
+		{"valid", Config{JWTSecret: "secret"}, r, false},
+		{"no JWT secret", Config{}, r, true},
+		{"no Redis", Config{JWTSecret: "secret"}, nil, true},
+		{"MFA", Config{JWTSecret: "secret", MFA: &MFAConfig{EncryptionKey: testEncryptionKey}}, r, false},
+		{"MFA with an AES-128 key", Config{JWTSecret: "secret", MFA: &MFAConfig{EncryptionKey: testEncryptionKey[:16]}}, r, false},
+		{"MFA without a key", Config{JWTSecret: "secret", MFA: &MFAConfig{}}, r, true},
+		{"MFA with a short key", Config{JWTSecret: "secret", MFA: &MFAConfig{EncryptionKey: []byte("short")}}, r, true},
+		{"MFA with a long key", Config{JWTSecret: "secret", MFA: &MFAConfig{EncryptionKey: append(testEncryptionKey, 'x')}}, r, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &authenticator{cfg: tt.cfg.withDefaults()}
+			err := a.init(common.InitModuleParams{Redis: tt.redis})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// HashParams It holds the argon2id parameters new passwords are hashed with.
+// Stored hashes created with other parameters are rehashed on login
+type HashParams struct {
+	// Memory It is the memory cost in KiB. Defaults to 64 MiB
+	Memory uint32
+	// Iterations It is the time cost. Defaults to 3
+	Iterations uint32
+	// Parallelism It is the number of lanes. Defaults to 2
+	Parallelism uint8
+	// SaltLength It is the length in bytes of the per-user salt. Defaults to 16
+	SaltLength uint32
+	// KeyLength It is the length in bytes of the derived key. Defaults to 32
+	KeyLength uint32
+}
+
+// DefaultHashParams It returns the argon2id parameters used when none are configured
+func DefaultHashParams() HashParams {
+	return HashParams{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (p HashParams) withDefaults() HashParams {
+	d := DefaultHashParams()
+	if p.Memory == 0 {
+		p.Memory = d.Memory
+	}
+	if p.Iterations == 0 {
+		p.Iterations = d.Iterations
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = d.Parallelism
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = d.SaltLength
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = d.KeyLength
+	}
+	return p
+}
+
+// HashPassword It hashes the password with argon2id and a random salt. The
+// result is encoded in the PHC string format
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func HashPassword(password string, params HashParams) (string, error) {
+	params = params.withDefaults()
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword It compares the password against the stored value in
+// constant time. A hashed value may be an argon2id or a bcrypt hash. A value
+// that is not hashed is a legacy row: it is a plaintext password unless it
+// is a well formed hash, written before the hashed column existed.
+// needsRehash tells if the stored value should be replaced by a hash created
+// with the given parameters
+func VerifyPassword(password, stored string, hashed bool, params HashParams) (ok bool, needsRehash bool, err error) {
+	if !hashed {
+		if !isHash(stored) {
+			ok = subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1
+			return ok, ok, nil
+		}
+		ok, _, err = VerifyPassword(password, stored, true, params)
+		return ok, ok, err
+	}
+	params = params.withDefaults()
+	switch {
+	case strings.HasPrefix(stored, argon2idPrefix):
+		hp, salt, key, err := decodeArgon2id(stored)
+		if err != nil {
+			return false, false, err
+		}
+		derived := argon2.IDKey([]byte(password), salt, hp.Iterations, hp.Memory, hp.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(derived, key) != 1 {
+			return false, false, nil
+		}
+		return true, hp != params, nil
+	case strings.HasPrefix(stored, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	}
+	return false, false, errors.New("unsupported password hash format")
+}
+
+// isHash It tells if a legacy value is an argon2id or a bcrypt hash rather
+// than a plaintext password
+func isHash(stored string) bool {
+	if _, _, _, err := decodeArgon2id(stored); err == nil {
+		return true
+	}
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
+
+// decodeArgon2id It parses an argon2id hash in the PHC string format
+func decodeArgon2id(encoded string) (HashParams, []byte, []byte, error) {
+	var hp HashParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return hp, nil, nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return hp, nil, nil, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return hp, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hp.Memory, &hp.Iterations, &hp.Parallelism); err != nil {
+		return hp, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return hp, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return hp, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	hp.SaltLength = uint32(len(salt))
+	hp.KeyLength = uint32(len(key))
+	return hp, salt, key, nil
+}
+
+// MigratePlaintextPasswords It replaces the legacy plaintext rows of
+// auth_passwords by argon2id hashes and returns the number of migrated rows.
+// Legacy rows already holding a hash are only marked as hashed. It is safe
+// to run more than once
+func MigratePlaintextPasswords(ctx context.Context, dbConfig *database.DBConfig, params HashParams) (int, error) {
+	q := dbConfig.GetDefaultQueries()
+	var rows []database.StoredPassword
+	if err := q.FetchLegacyPasswords.SelectContext(ctx, &rows); err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, row := range rows {
+		hash := row.Password
+		if !isHash(hash) {
+			var err error
+			if hash, err = HashPassword(row.Password, params); err != nil {
+				return migrated, err
+			}
+		}
+		if _, err := q.UpdatePassword.ExecContext(ctx, hash, row.UserId); err != nil {
+			return migrated, fmt.Errorf("updating password of user %d: %w", row.UserId, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
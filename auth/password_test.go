@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testHashParams It keeps the tests fast
+var testHashParams = HashParams{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func mustHash(t *testing.T, password string, params HashParams) string {
+	t.Helper()
+	hash, err := HashPassword(password, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestHashPassword(t *testing.T) {
+	hash := mustHash(t, "correct horse", testHashParams)
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("unexpected encoding %s", hash)
+	}
+	hp, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hp != testHashParams.withDefaults() || len(salt) != 16 || len(key) != 32 {
+		t.Errorf("decoded %+v with a %d bytes salt and a %d bytes key", hp, len(salt), len(key))
+	}
+	if hash == mustHash(t, "correct horse", testHashParams) {
+		t.Error("two hashes of the same password are equal, the salt is not random")
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	const password = "correct horse"
+	argon := mustHash(t, password, testHashParams)
+	weaker := mustHash(t, password, HashParams{Memory: 512, Iterations: 1, Parallelism: 1})
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash := string(b)
+
+	tests := []struct {
+		name       string
+		password   string
+		stored     string
+		hashed     bool
+		wantOK     bool
+		wantRehash bool
+		wantErr    bool
+	}{
+		{"argon2id", password, argon, true, true, false, false},
+		{"argon2id wrong password", "wrong", argon, true, false, false, false},
+		{"argon2id other parameters", password, weaker, true, true, true, false},
+		{"bcrypt", password, bcryptHash, true, true, true, false},
+		{"bcrypt wrong password", "wrong", bcryptHash, true, false, false, false},
+		{"legacy plaintext", password, password, false, true, true, false},
+		{"legacy plaintext wrong password", "wrong", password, false, false, false, false},
+		{"legacy bcrypt", password, bcryptHash, false, true, true, false},
+		{"legacy bcrypt with the hash as password", bcryptHash, bcryptHash, false, false, false, false},
+		{"legacy argon2id", password, argon, false, true, true, false},
+		{"unsupported hash", password, "$md5$abc", true, false, false, true},
+		{"corrupted argon2id", password, "$argon2id$v=19$m=1024$x$y", true, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := VerifyPassword(tt.password, tt.stored, tt.hashed, testHashParams)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyPassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("VerifyPassword() = %v, %v, want %v, %v", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestLoginRehash(t *testing.T) {
+	s := newServices(t)
+	weak := HashParams{Memory: 512, Iterations: 1, Parallelism: 1}
+	user := s.signup(t, newTestAuthenticator(Config{HashParams: weak}), "correct horse").User
+
+	stored := func() (string, bool) {
+		t.Helper()
+		var row struct {
+			Password string `db:"password"`
+			Hashed   bool   `db:"hashed"`
+		}
+		if err := s.db.GetDB().Get(&row, "select password, hashed from auth_passwords where user_id = $1", user.ID); err != nil {
+			t.Fatal(err)
+		}
+		return row.Password, row.Hashed
+	}
+	login := func(a *authenticator, password string) int {
+		t.Helper()
+		_, herr := a.login(s.context(), Credentials{Username: user.Username, Password: password})
+		return statusOf(herr)
+	}
+
+	// Logging in with other parameters rehashes the password.
+	a := newTestAuthenticator(Config{})
+	before, _ := stored()
+	if got := login(a, "correct horse"); got != 0 {
+		t.Fatalf("login got status %d", got)
+	}
+	after, hashed := stored()
+	if after == before || !hashed || !strings.HasPrefix(after, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("password was not rehashed with the new parameters: %s", after)
+	}
+	if got := login(a, "wrong password"); got != http.StatusUnauthorized {
+		t.Errorf("wrong password got status %d", got)
+	}
+
+	// A legacy bcrypt row is verified as bcrypt and rehashed with argon2id.
+	b, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.GetDB().Exec("update auth_passwords set password = $1, hashed = false where user_id = $2", string(b), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := login(a, string(b)); got != http.StatusUnauthorized {
+		t.Errorf("logging in with the bcrypt hash itself got status %d", got)
+	}
+	if got := login(a, "correct horse"); got != 0 {
+		t.Fatalf("login with a legacy bcrypt row got status %d", got)
+	}
+	if after, hashed := stored(); !hashed || !strings.HasPrefix(after, argon2idPrefix) {
+		t.Errorf("legacy bcrypt row was not rehashed: %s, hashed %v", after, hashed)
+	}
+}
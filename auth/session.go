@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"net/http"
+	"time"
+)
+
+// refreshCookieName It is scoped to the module path so that the refresh
+// token is only sent to the auth controllers
+const refreshCookieName = "refresh_token"
+
+const (
+	// sessionSweepInterval It is how often the expired sessions are deleted
+	sessionSweepInterval = time.Hour
+	sessionSweepTimeout  = time.Minute
+)
+
+var errInvalidRefreshToken = &common.HTTPError{Code: http.StatusUnauthorized, Message: "Invalid refresh token"}
+
+// RefreshRequest It carries the refresh token. When empty the refresh
+// token cookie is used
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type refreshToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// hashRefreshToken It returns the value stored in auth_sessions. Only the
+// hash is persisted so that a database leak does not expose live tokens
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createRefreshToken It stores a new refresh token of the family
+func (a *authenticator) createRefreshToken(c context.Context, stmt *sqlx.Stmt, familyId string, userId int64) (*refreshToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := &refreshToken{
+		value:     base64.RawURLEncoding.EncodeToString(b),
+		expiresAt: time.Now().Add(a.cfg.RefreshTokenTTL),
+	}
+	if _, err := stmt.ExecContext(c, uuid.NewString(), familyId, userId, hashRefreshToken(token.value), token.expiresAt); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// startSession It creates a refresh token family for the user and issues
+// the tokens
+func (a *authenticator) startSession(ctx common.AppContext, user Profile) (*Session, *common.HTTPError) {
+	sessionId := uuid.NewString()
+	refresh, err := a.createRefreshToken(ctx.Context(), ctx.DBConfig.GetDefaultQueries().CreateAuthSession, sessionId, user.ID)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to start session", err)
+	}
+	token, err := a.issueToken(ctx, user.ID, sessionId, refresh)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to issue token", err)
+	}
+	a.sweepSessions(ctx)
+	return &Session{User: user, Token: token}, nil
+}
+
+// sweepSessions It deletes the expired sessions in the background, at most
+// once per sessionSweepInterval
+func (a *authenticator) sweepSessions(ctx common.AppContext) {
+	a.sweepMu.Lock()
+	if time.Since(a.lastSweep) < sessionSweepInterval {
+		a.sweepMu.Unlock()
+		return
+	}
+	a.lastSweep = time.Now()
+	a.sweepMu.Unlock()
+
+	stmt := ctx.DBConfig.GetDefaultQueries().DeleteExpiredAuthSessions
+	c, cancel := context.WithTimeout(context.WithoutCancel(ctx.Context()), sessionSweepTimeout)
+	go func() {
+		defer cancel()
+		res, err := stmt.ExecContext(c)
+		if err != nil {
+			ctx.Logger.Error("Failed to delete expired sessions", "error", err)
+			return
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			ctx.Logger.Info("Deleted expired sessions", "count", n)
+		}
+	}()
+}
+
+// refreshTokenFrom It returns the refresh token of the request body or cookie
+func refreshTokenFrom(ctx common.AppContext, req RefreshRequest) string {
+	if req.RefreshToken != "" {
+		return req.RefreshToken
+	}
+	if cookie, err := ctx.HTTPServerContext.Cookie(refreshCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// revokeAccessTokens It adds the sessions to the revocation list checked by
+// the JWT middleware. Their refresh tokens must already be revoked
+func (a *authenticator) revokeAccessTokens(ctx common.AppContext, sessionIds ...string) error {
+	var errs []error
+	for _, id := range sessionIds {
+		errs = append(errs, common.RevokeSession(ctx.Context(), ctx.Redis, id, a.cfg.TokenTTL))
+	}
+	return errors.Join(errs...)
+}
+
+// refresh It exchanges a refresh token for a new access and refresh token.
+// Each refresh token can be used once. Presenting an already rotated token
+// means it was stolen, so the whole family is revoked
+func (a *authenticator) refresh(ctx common.AppContext, req RefreshRequest) (*Session, *common.HTTPError) {
+	raw := refreshTokenFrom(ctx, req)
+	if raw == "" {
+		return nil, errInvalidRefreshToken
+	}
+	c := ctx.Context()
+	q := ctx.DBConfig.GetDefaultQueries()
+
+	tx, err := ctx.DBConfig.GetDB().BeginTxx(c, nil)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to refresh token", err)
+	}
+	defer tx.Rollback()
+
+	var s database.AuthSession
+	err = tx.StmtxContext(c, q.FetchAuthSessionForUpdate).GetContext(c, &s, hashRefreshToken(raw))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, internalError(ctx, "Failed to refresh token", err)
+	}
+	if s.RevokedAt.Valid {
+		a.clearCookies(ctx)
+		return nil, errInvalidRefreshToken
+	}
+	if s.RotatedAt.Valid {
+		ctx.Logger.Warn("Refresh token reuse detected, revoking the session", "userId", s.UserId, "sessionId", s.FamilyID)
+		if _, err := tx.StmtxContext(c, q.RevokeAuthSessionFamily).ExecContext(c, s.FamilyID); err != nil {
+			return nil, internalError(ctx, "Failed to refresh token", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, internalError(ctx, "Failed to refresh token", err)
+		}
+		if err := a.revokeAccessTokens(ctx, s.FamilyID); err != nil {
+			ctx.Logger.Error("Failed to revoke access tokens", "sessionId", s.FamilyID, "error", err)
+		}
+		a.clearCookies(ctx)
+		return nil, errInvalidRefreshToken
+	}
+	if time.Now().After(s.ExpiresAt) {
+		a.clearCookies(ctx)
+		return nil, errInvalidRefreshToken
+	}
+
+	if _, err := tx.StmtxContext(c, q.RotateAuthSession).ExecContext(c, s.ID); err != nil {
+		return nil, internalError(ctx, "Failed to refresh token", err)
+	}
+	next, err := a.createRefreshToken(c, tx.StmtxContext(c, q.CreateAuthSession), s.FamilyID, s.UserId)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to refresh token", err)
+	}
+	var profile database.AuthProfile
+	if err := tx.StmtxContext(c, q.FetchAuthProfileById).GetContext(c, &profile, s.UserId); err != nil {
+		return nil, internalError(ctx, "Failed to refresh token", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, internalError(ctx, "Failed to refresh token", err)
+	}
+
+	token, err := a.issueToken(ctx, s.UserId, s.FamilyID, next)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to issue token", err)
+	}
+	return &Session{User: *profileOf(&profile), Token: token}, nil
+}
+
+// logout It revokes the session of the refresh token, if any, and clears
+// the cookies
+func (a *authenticator) logout(ctx common.AppContext, req RefreshRequest) (*empty, *common.HTTPError) {
+	defer a.clearCookies(ctx)
+	raw := refreshTokenFrom(ctx, req)
+	if raw == "" {
+		return &empty{}, nil
+	}
+	c := ctx.Context()
+	q := ctx.DBConfig.GetDefaultQueries()
+
+	tx, err := ctx.DBConfig.GetDB().BeginTxx(c, nil)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to log out", err)
+	}
+	defer tx.Rollback()
+
+	var s database.AuthSession
+	err = tx.StmtxContext(c, q.FetchAuthSessionForUpdate).GetContext(c, &s, hashRefreshToken(raw))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &empty{}, nil
+	}
+	if err != nil {
+		return nil, internalError(ctx, "Failed to log out", err)
+	}
+	if _, err := tx.StmtxContext(c, q.RevokeAuthSessionFamily).ExecContext(c, s.FamilyID); err != nil {
+		return nil, internalError(ctx, "Failed to log out", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, internalError(ctx, "Failed to log out", err)
+	}
+	if err := a.revokeAccessTokens(ctx, s.FamilyID); err != nil {
+		return nil, internalError(ctx, "Failed to revoke access tokens", err)
+	}
+	return &empty{}, nil
+}
+
+// logoutAll It revokes every session of the authenticated user
+func (a *authenticator) logoutAll(ctx common.AppContext, _ empty) (*empty, *common.HTTPError) {
+	userId, herr := userIdFromClaims(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	var sessionIds []string
+	if err := ctx.DBConfig.GetDefaultQueries().RevokeUserAuthSessions.SelectContext(ctx.Context(), &sessionIds, userId); err != nil {
+		return nil, internalError(ctx, "Failed to log out", err)
+	}
+	if err := a.revokeAccessTokens(ctx, sessionIds...); err != nil {
+		return nil, internalError(ctx, "Failed to revoke access tokens", err)
+	}
+	a.clearCookies(ctx)
+	ctx.Logger.Info("User logged out everywhere", "userId", userId, "sessions", len(sessionIds))
+	return &empty{}, nil
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"net/http"
+	"testing"
+)
+
+// sessionIdOf returns the `sid` claim of an access token
+func sessionIdOf(t *testing.T, a *authenticator, token string) string {
+	t.Helper()
+	var claims common.Claims
+	if _, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(a.cfg.JWTSecret), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return claims.SessionID
+}
+
+func TestRefreshRotation(t *testing.T) {
+	s := newServices(t)
+	a := newTestAuthenticator(Config{})
+	first := s.signup(t, a, "correct horse")
+	sid := sessionIdOf(t, a, first.Token.AccessToken)
+
+	second, herr := a.refresh(s.context(), RefreshRequest{RefreshToken: first.Token.RefreshToken})
+	if herr != nil {
+		t.Fatalf("refresh: %v", herr)
+	}
+	if second.Token.RefreshToken == first.Token.RefreshToken {
+		t.Fatal("the refresh token was not rotated")
+	}
+	if got := sessionIdOf(t, a, second.Token.AccessToken); got != sid {
+		t.Errorf("session id changed from %s to %s on refresh", sid, got)
+	}
+	if second.User.ID != first.User.ID {
+		t.Errorf("refresh returned user %d, want %d", second.User.ID, first.User.ID)
+	}
+
+	third, herr := a.refresh(s.context(), RefreshRequest{RefreshToken: second.Token.RefreshToken})
+	if herr != nil {
+		t.Fatalf("refresh of the rotated token: %v", herr)
+	}
+
+	// Presenting the first token again means it was stolen: the whole
+	// family is revoked, including the token issued last, and so are the
+	// access tokens of the session.
+	if _, herr := a.refresh(s.context(), RefreshRequest{RefreshToken: first.Token.RefreshToken}); statusOf(herr) != http.StatusUnauthorized {
+		t.Fatalf("reusing a rotated token got %v, want a 401", herr)
+	}
+	if _, herr := a.refresh(s.context(), RefreshRequest{RefreshToken: third.Token.RefreshToken}); statusOf(herr) != http.StatusUnauthorized {
+		t.Errorf("the last token of a revoked family got %v, want a 401", herr)
+	}
+	if !s.mr.Exists("TB:REVOKED:SID:" + sid) {
+		t.Error("the access tokens of the session were not revoked")
+	}
+
+	// Other sessions of the user are not affected.
+	other, herr := a.login(s.context(), Credentials{Username: first.User.Username, Password: "correct horse"})
+	if herr != nil {
+		t.Fatalf("login: %v", herr)
+	}
+	if _, herr := a.refresh(s.context(), RefreshRequest{RefreshToken: other.Token.RefreshToken}); herr != nil {
+		t.Errorf("refresh of another session: %v", herr)
+	}
+}
+
+func TestRefreshInvalidToken(t *testing.T) {
+	s := newServices(t)
+	a := newTestAuthenticator(Config{})
+	for _, token := range []string{"", "unknown"} {
+		if _, herr := a.refresh(s.context(), RefreshRequest{RefreshToken: token}); statusOf(herr) != http.StatusUnauthorized {
+			t.Errorf("refresh(%q) got %v, want a 401", token, herr)
+		}
+	}
+}
+
+func TestLogoutRevokesFamily(t *testing.T) {
+	s := newServices(t)
+	a := newTestAuthenticator(Config{})
+	first := s.signup(t, a, "correct horse")
+	second, herr := a.refresh(s.context(), RefreshRequest{RefreshToken: first.Token.RefreshToken})
+	if herr != nil {
+		t.Fatalf("refresh: %v", herr)
+	}
+	if _, herr := a.logout(s.context(), RefreshRequest{RefreshToken: second.Token.RefreshToken}); herr != nil {
+		t.Fatalf("logout: %v", herr)
+	}
+	if _, herr := a.refresh(s.context(), RefreshRequest{RefreshToken: second.Token.RefreshToken}); statusOf(herr) != http.StatusUnauthorized {
+		t.Errorf("refresh after logout got %v, want a 401", herr)
+	}
+	if !s.mr.Exists("TB:REVOKED:SID:" + sessionIdOf(t, a, second.Token.AccessToken)) {
+		t.Error("the access tokens of the session were not revoked")
+	}
+}
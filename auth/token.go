@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// issueToken It signs an access token for the session of the user and sets
+// it as the cookie read by the JWT middleware along with the refresh token
+func (a *authenticator) issueToken(ctx common.AppContext, userId int64, sessionId string, refresh *refreshToken) (*Token, error) {
+	now := time.Now()
+	expiresAt := now.Add(a.cfg.TokenTTL)
+	claims := common.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   strconv.FormatInt(userId, 10),
+			Issuer:    a.cfg.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		SessionID: sessionId,
+	}
+	if a.cfg.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{a.cfg.Audience}
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(a.cfg.JWTSecret))
+	if err != nil {
+		return nil, err
+	}
+	a.setCookie(ctx, tokenCookieName, "/", signed, expiresAt)
+	a.setCookie(ctx, refreshCookieName, string(a.cfg.ModulePath), refresh.value, refresh.expiresAt)
+	return &Token{
+		AccessToken:      signed,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refresh.value,
+		RefreshExpiresAt: refresh.expiresAt,
+	}, nil
+}
+
+// clearCookies It removes the access and refresh token cookies
+func (a *authenticator) clearCookies(ctx common.AppContext) {
+	a.setCookie(ctx, tokenCookieName, "/", "", time.Unix(0, 0))
+	a.setCookie(ctx, refreshCookieName, string(a.cfg.ModulePath), "", time.Unix(0, 0))
+}
+
+// setCookie It sets an HTTP only cookie. An empty value clears it
+func (a *authenticator) setCookie(ctx common.AppContext, name, path, value string, expiresAt time.Time) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   a.cfg.CookieDomain,
+		Expires:  expiresAt,
+		Secure:   a.cfg.CookieSecure,
+		HttpOnly: true,
+		SameSite: a.cfg.CookieSameSite,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	ctx.SetCookie(cookie)
+}
@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"github.com/suhailgupta03/thunderbyte/otp"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/providers/smtp"
+	"github.com/suhailgupta03/thunderbyte/otp/store"
+	"github.com/suhailgupta03/thunderbyte/otp/store/redis"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	// PasswordResetNamespace It is the OTP namespace of the password reset flow
+	PasswordResetNamespace = "auth.password-reset"
+	// EmailVerificationNamespace It is the OTP namespace of the e-mail verification flow
+	EmailVerificationNamespace = "auth.email-verification"
+
+	defaultOTPTTL               = 10 * time.Minute
+	defaultOTPMaxAttempts       = 5
+	defaultPasswordResetSubject = "Your password reset code is {{ .OTP }}"
+	defaultVerifyEmailSubject   = "Your e-mail verification code is {{ .OTP }}"
+)
+
+// OTPConfig It enables the password reset and e-mail verification flows.
+// Codes are e-mailed through the smtp provider of the otp package
+type OTPConfig struct {
+	// Store It keeps the codes. Defaults to the OTP store of the app, or
+	// a Redis store on AppContext.Redis when the app has no OTP service
+	Store store.Store
+	// Provider It is the ID of the OTP provider the codes are sent with. It
+	// is looked up in the OTP service of the app. Defaults to "smtp"
+	Provider string
+	// SMTP If set the codes are e-mailed through this server instead of
+	// the OTP service of the app. When SMTPPoolConnection is not set the
+	// SMTP pool of the app is used
+	SMTP *smtp.Config
+	// RootURL It is the URL where the server is running
+	RootURL string
+	// TTL It is the lifetime of a code. Defaults to 10 minutes
+	TTL time.Duration
+	// MaxAttempts It is the number of tries allowed per code. Defaults to 5
+	MaxAttempts int
+	// PasswordResetTemplate It is the path of the HTML template of the
+	// password reset e-mail. The template receives the fields of the otp
+	// package, e.g. {{ .OTP }} and {{ .OTPTTL }}
+	PasswordResetTemplate string
+	// PasswordResetSubject It is the subject template of the password reset e-mail
+	PasswordResetSubject string
+	// EmailVerificationTemplate It is the path of the HTML template of the
+	// e-mail verification e-mail
+	EmailVerificationTemplate string
+	// EmailVerificationSubject It is the subject template of the e-mail
+	// verification e-mail
+	EmailVerificationSubject string
+}
+
+func (c *OTPConfig) withDefaults() *OTPConfig {
+	out := *c
+	if out.Provider == "" {
+		out.Provider = smtp.ProviderID
+	}
+	if out.TTL == 0 {
+		out.TTL = defaultOTPTTL
+	}
+	if out.MaxAttempts == 0 {
+		out.MaxAttempts = defaultOTPMaxAttempts
+	}
+	if out.PasswordResetSubject == "" {
+		out.PasswordResetSubject = defaultPasswordResetSubject
+	}
+	if out.EmailVerificationSubject == "" {
+		out.EmailVerificationSubject = defaultVerifyEmailSubject
+	}
+	return &out
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+type ResetPasswordRequest struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	OTP      string `json:"otp" validate:"required,max=16"`
+	Password string `json:"password" validate:"required,min=8,max=128"`
+}
+
+type VerifyEmailRequest struct {
+	OTP string `json:"otp" validate:"required,max=16"`
+}
+
+// otpID It is the ID a code is stored under. One code per user and flow
+// can be pending at a time
+func otpID(userId int64) string {
+	return fmt.Sprintf("user-%d", userId)
+}
+
+// initOTP It is run by init when OTP is set. The module uses the OTP
+// service of the app unless it is configured with its own SMTP server or
+// store, in which case its own service is built. The templates of the
+// flows are added to the registry of the service in use
+func (a *authenticator) initOTP(p common.InitModuleParams) error {
+	cfg := a.cfg.OTP
+	service := p.OTP
+	if cfg.SMTP != nil || cfg.Store != nil || service == nil {
+		s, err := a.newOTPService(p)
+		if err != nil {
+			return err
+		}
+		a.otp, service = s, s
+	}
+
+	registry := service.Registry()
+	if !slices.Contains(registry.IDs(), cfg.Provider) {
+		return fmt.Errorf("OTP provider '%s' is not enabled", cfg.Provider)
+	}
+	if err := registry.AddTemplate(cfg.PasswordResetSubject, cfg.PasswordResetTemplate); err != nil {
+		return err
+	}
+	return registry.AddTemplate(cfg.EmailVerificationSubject, cfg.EmailVerificationTemplate)
+}
+
+// newOTPService It builds the OTP service of the module from its config
+// and the services of the app
+func (a *authenticator) newOTPService(p common.InitModuleParams) (*otp.Service, error) {
+	cfg := a.cfg.OTP
+	s := cfg.Store
+	if s == nil && p.OTP != nil {
+		s = p.OTP.Store()
+	}
+	if s == nil && p.Redis != nil {
+		s = redis.NewWithClient(p.Redis.Client(), redis.Conf{})
+	}
+	if s == nil {
+		return nil, errors.New("no OTP store configured")
+	}
+
+	var registry *otp.Registry
+	if cfg.SMTP == nil && p.OTP != nil {
+		registry = p.OTP.Registry()
+	} else {
+		smtpConfig := smtp.Config{}
+		if cfg.SMTP != nil {
+			smtpConfig = *cfg.SMTP
+		}
+		if smtpConfig.SMTPPoolConnection == nil && smtpConfig.Host == "" {
+			smtpConfig.SMTPPoolConnection = p.SMTPPool
+		}
+		if smtpConfig.SMTPPoolConnection == nil && smtpConfig.Host == "" {
+			return nil, errors.New("no OTP provider configured")
+		}
+		provider, err := smtp.New(smtpConfig)
+		if err != nil {
+			return nil, err
+		}
+		registry = otp.NewRegistry()
+		if err := registry.Add(cfg.Provider, provider, models.ProviderConfig{}); err != nil {
+			return nil, err
+		}
+	}
+	return otp.NewService(otp.ServiceConfig{Registry: registry, Store: s, Logger: p.Logger})
+}
+
+// otpService It returns the service built by initOTP, or else the OTP
+// service of the app
+func (a *authenticator) otpService(ctx common.AppContext) (*otp.Service, error) {
+	if a.otp != nil {
+		return a.otp, nil
+	}
+	if ctx.OTP != nil {
+		return ctx.OTP, nil
+	}
+	return nil, errors.New("no OTP service configured")
+}
+
+// sendOTP It generates a code in the namespace and sends it to the address
+func (a *authenticator) sendOTP(ctx common.AppContext, namespace string, userId int64, to string) *common.HTTPError {
+	service, err := a.otpService(ctx)
+	if err != nil {
+		return internalError(ctx, "Failed to send code", err)
+	}
+	if err := a.setOTP(ctx.Context(), service, namespace, userId, to); err != nil {
+		return otpHTTPError(ctx, err)
+	}
+	return nil
+}
+
+// setOTP It generates a code in the namespace with the templates of the
+// namespace and sends it to the address
+func (a *authenticator) setOTP(c context.Context, service *otp.Service, namespace string, userId int64, to string) error {
+	cfg := a.cfg.OTP
+	template, subject := cfg.PasswordResetTemplate, cfg.PasswordResetSubject
+	if namespace == EmailVerificationNamespace {
+		template, subject = cfg.EmailVerificationTemplate, cfg.EmailVerificationSubject
+	}
+	_, err := service.Set(c, otp.SetRequest{
+		RootURL:     cfg.RootURL,
+		Namespace:   namespace,
+		Provider:    cfg.Provider,
+		ID:          otpID(userId),
+		To:          to,
+		TTL:         cfg.TTL,
+		MaxAttempts: cfg.MaxAttempts,
+		Subject:     subject,
+		Template:    template,
+		Send:        true,
+	})
+	return err
+}
+
+// verifyOTP It checks the code of the user in the namespace
+func (a *authenticator) verifyOTP(ctx common.AppContext, namespace string, userId int64, code string) *common.HTTPError {
+	service, err := a.otpService(ctx)
+	if err != nil {
+		return internalError(ctx, "Failed to verify code", err)
+	}
+	if _, err := service.Verify(ctx.Context(), namespace, otpID(userId), code); err != nil {
+		return otpHTTPError(ctx, err)
+	}
+	return nil
+}
+
+// otpHTTPError It maps the errors of the otp package to HTTP errors
+func otpHTTPError(ctx common.AppContext, err error) *common.HTTPError {
+	var otpErr *otp.OTPError
+	if !errors.As(err, &otpErr) {
+		return internalError(ctx, "Failed to process code", err)
+	}
+	switch otpErr.ErrorCode {
+	case otp.InvalidOTP, otp.OTPExpired:
+		return &common.HTTPError{Code: http.StatusBadRequest, Message: otpErr.Message}
+	case otp.MaxAttemptsExceeded:
+		return &common.HTTPError{Code: http.StatusTooManyRequests, Message: otpErr.Message}
+	}
+	return internalError(ctx, "Failed to process code", err)
+}
+
+// forgotPassword It e-mails a password reset code. The address is looked up
+// and the code sent in the background, so that neither the response nor its
+// timing tell whether the address is registered
+func (a *authenticator) forgotPassword(ctx common.AppContext, req ForgotPasswordRequest) (*empty, *common.HTTPError) {
+	// The request context is canceled once the response is written
+	c := context.WithoutCancel(ctx.Context())
+	a.mails.Add(1)
+	go func() {
+		defer a.mails.Done()
+		a.sendPasswordReset(c, ctx, req.Email)
+	}()
+	return &empty{}, nil
+}
+
+// sendPasswordReset It e-mails a password reset code to the user registered
+// with the address, if any. Failures are logged since the response is
+// already sent. It must not use the echo context of ctx, which is reused
+// once the response is written
+func (a *authenticator) sendPasswordReset(c context.Context, ctx common.AppContext, email string) {
+	var profile database.AuthProfile
+	err := ctx.DBConfig.GetDefaultQueries().FetchAuthProfileByEmail.GetContext(c, &profile, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		ctx.Logger.Error("Failed to fetch the user of a password reset", "error", err)
+		return
+	}
+	service, err := a.otpService(ctx)
+	if err == nil {
+		err = a.setOTP(c, service, PasswordResetNamespace, profile.UserId, profile.Email.String)
+	}
+	if err != nil {
+		ctx.Logger.Error("Failed to send password reset code", "userId", profile.UserId, "error", err)
+	}
+}
+
+// resetPassword It sets a new password once the code is verified. Every
+// session of the user is revoked
+func (a *authenticator) resetPassword(ctx common.AppContext, req ResetPasswordRequest) (*empty, *common.HTTPError) {
+	q := ctx.DBConfig.GetDefaultQueries()
+	var profile database.AuthProfile
+	err := q.FetchAuthProfileByEmail.GetContext(ctx.Context(), &profile, req.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &common.HTTPError{Code: http.StatusBadRequest, Message: "Incorrect Passcode"}
+	}
+	if err != nil {
+		return nil, internalError(ctx, "Failed to reset password", err)
+	}
+	if herr := a.verifyOTP(ctx, PasswordResetNamespace, profile.UserId, req.OTP); herr != nil {
+		return nil, herr
+	}
+
+	hash, err := HashPassword(req.Password, a.cfg.HashParams)
+	if err != nil {
+		return nil, internalError(ctx, "Failed to reset password", err)
+	}
+	if _, err := q.UpdatePassword.ExecContext(ctx.Context(), hash, profile.UserId); err != nil {
+		return nil, internalError(ctx, "Failed to reset password", err)
+	}
+	var sessionIds []string
+	if err := q.RevokeUserAuthSessions.SelectContext(ctx.Context(), &sessionIds, profile.UserId); err != nil {
+		return nil, internalError(ctx, "Failed to revoke sessions", err)
+	}
+	if err := a.revokeAccessTokens(ctx, sessionIds...); err != nil {
+		return nil, internalError(ctx, "Failed to revoke access tokens", err)
+	}
+	a.clearCookies(ctx)
+	ctx.Logger.Info("Password reset", "userId", profile.UserId)
+	return &empty{}, nil
+}
+
+// sendEmailVerification It e-mails a verification code to the address of
+// the authenticated user
+func (a *authenticator) sendEmailVerification(ctx common.AppContext, _ empty) (*empty, *common.HTTPError) {
+	profile, herr := a.currentProfile(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	if !profile.Email.Valid {
+		return nil, &common.HTTPError{Code: http.StatusBadRequest, Message: "No e-mail address on the account"}
+	}
+	if profile.EmailVerifiedAt.Valid {
+		return nil, &common.HTTPError{Code: http.StatusConflict, Message: "E-mail address is already verified"}
+	}
+	if herr := a.sendOTP(ctx, EmailVerificationNamespace, profile.UserId, profile.Email.String); herr != nil {
+		return nil, herr
+	}
+	return &empty{}, nil
+}
+
+// verifyEmail It records email_verified_at once the code is verified
+func (a *authenticator) verifyEmail(ctx common.AppContext, req VerifyEmailRequest) (*Profile, *common.HTTPError) {
+	profile, herr := a.currentProfile(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	if !profile.Email.Valid {
+		return nil, &common.HTTPError{Code: http.StatusBadRequest, Message: "No e-mail address on the account"}
+	}
+	if herr := a.verifyOTP(ctx, EmailVerificationNamespace, profile.UserId, req.OTP); herr != nil {
+		return nil, herr
+	}
+	q := ctx.DBConfig.GetDefaultQueries()
+	if _, err := q.MarkEmailVerified.ExecContext(ctx.Context(), profile.UserId, profile.Email.String); err != nil {
+		return nil, internalError(ctx, "Failed to verify e-mail", err)
+	}
+	profile, herr = a.currentProfile(ctx)
+	if herr != nil {
+		return nil, herr
+	}
+	return profileOf(profile), nil
+}
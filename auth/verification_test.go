@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/suhailgupta03/thunderbyte/otp"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/store/redis"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider records the addresses it pushes codes to, or fails every
+// push when fail is set
+type fakeProvider struct {
+	mu   sync.Mutex
+	to   []string
+	fail bool
+}
+
+func (p *fakeProvider) ID() string                   { return "fake" }
+func (p *fakeProvider) ChannelName() string          { return "E-mail" }
+func (p *fakeProvider) ChannelDesc() string          { return "" }
+func (p *fakeProvider) AddressName() string          { return "E-mail" }
+func (p *fakeProvider) AddressDesc() string          { return "" }
+func (p *fakeProvider) ValidateAddress(string) error { return nil }
+func (p *fakeProvider) MaxAddressLen() int           { return 254 }
+func (p *fakeProvider) MaxOTPLen() int               { return 16 }
+func (p *fakeProvider) MaxBodyLen() int              { return 4096 }
+func (p *fakeProvider) Push(_ context.Context, o models.OTP, _ string, _ []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail {
+		return errors.New("provider is down")
+	}
+	p.to = append(p.to, o.To)
+	return nil
+}
+
+func (p *fakeProvider) pushed() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.to...)
+}
+
+func TestForgotPassword(t *testing.T) {
+	s := newServices(t)
+	provider := &fakeProvider{}
+	registry := otp.NewRegistry()
+	if err := registry.Add(provider.ID(), provider, models.ProviderConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	service, err := otp.NewService(otp.ServiceConfig{Registry: registry, Store: redis.NewWithClient(s.redis.Client(), redis.Conf{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAuthenticator(Config{OTP: &OTPConfig{Provider: provider.ID()}})
+	a.otp = service
+
+	email := fmt.Sprintf("user_%d@example.com", time.Now().UnixNano())
+	name := fmt.Sprintf("user_%d_%d", time.Now().UnixNano(), userSeq.Add(1))
+	// Signing up without OTP does not send a verification code
+	if _, herr := newTestAuthenticator(Config{}).signup(s.context(), SignupRequest{Username: name, Password: "password123", Email: email}); herr != nil {
+		t.Fatalf("signup: %v", herr)
+	}
+
+	tests := []struct {
+		name       string
+		email      string
+		fail       bool
+		wantPushed []string
+	}{
+		{"registered address", email, false, []string{email}},
+		{"provider failure", email, true, nil},
+		{"unknown address", "nobody_" + email, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.mu.Lock()
+			provider.to, provider.fail = nil, tt.fail
+			provider.mu.Unlock()
+
+			res, herr := a.forgotPassword(s.context(), ForgotPasswordRequest{Email: tt.email})
+			if herr != nil || res == nil {
+				t.Fatalf("forgotPassword() = %v, %v, want an empty response", res, herr)
+			}
+			a.mails.Wait()
+			if got := provider.pushed(); fmt.Sprint(got) != fmt.Sprint(tt.wantPushed) {
+				t.Errorf("codes pushed to %v, want %v", got, tt.wantPushed)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// ErrMiss It is returned by Get when the key does not exist
+var ErrMiss = errors.New("cache miss")
+
+// Redis It is the general purpose Redis service of the app, exposed to the
+// handlers as AppContext.Redis. It is independent of the OTP store, which
+// may or may not share its client
+type Redis struct {
+	client redis.UniversalClient
+}
+
+// New It wraps a go-redis client. The client is owned by the service and
+// closed by Close
+func New(client redis.UniversalClient) *Redis {
+	return &Redis{client: client}
+}
+
+// Client It returns the underlying go-redis client for the commands that
+// are not covered by the service
+func (r *Redis) Client() redis.UniversalClient {
+	return r.client
+}
+
+// Get It returns the value of the key or ErrMiss if it does not exist
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := r.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMiss
+	}
+	return b, err
+}
+
+// Set It sets the value of the key. A ttl of 0 keeps the key until it is
+// deleted
+func (r *Redis) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Exists It tells if the key exists
+func (r *Redis) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := r.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Delete It deletes the keys. Missing keys are ignored
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	return r.client.Del(ctx, keys...).Err()
+}
+
+// Incr It increments the counter at key and sets its ttl when the key is
+// created. It returns the new value of the counter. The key is created
+// with its ttl and incremented in one transaction so that a counter never
+// outlives its ttl
+func (r *Redis) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetNX(ctx, key, 0, ttl)
+		incr = pipe.Incr(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// Ping It checks if the Redis server is reachable
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Close It closes the client and its connection pool
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -1,15 +1,16 @@
 package common
 
 import (
+	"context"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/knadh/koanf/v2"
-	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/suhailgupta03/smtppool"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
 	"github.com/suhailgupta03/thunderbyte/database"
-	"github.com/suhailgupta03/thunderbyte/otp/store/redis"
+	"github.com/suhailgupta03/thunderbyte/otp"
 	"github.com/zerodha/logf"
 	"net/http"
-	"reflect"
 	"strings"
 	"time"
 )
@@ -35,20 +36,38 @@ type AppContext struct {
 	RequestContext    RequestContext
 	HTTPServerContext echo.Context
 	DBConfig          *database.DBConfig
-	Redis             *redis.Redis
-	SMTPPool          *smtppool.Pool
-	Logger            *logf.Logger
-	K                 *koanf.Koanf
-	Q                 interface{}
+	// Redis It is the general purpose Redis service of the app. It is not
+	// tied to the OTP store, see OTP.Store()
+	Redis    *cache.Redis
+	SMTPPool *smtppool.Pool
+	// OTP It sets and verifies codes with the providers initialized at
+	// startup. It is nil unless FactoryCreate.OTPProviders is set
+	OTP    *otp.Service
+	Logger *logf.Logger
+	K      *koanf.Koanf
+	Q      interface{}
+	// Services It is the map of services injected into the module
+	Services *InjectedServicesMap
+	// Claims It holds the claims of the verified JWT. It is nil on routes
+	// without JWT verification. See ClaimsAs
+	Claims jwt.Claims
+	authz  *authorizationMemo
 }
 
 func (ctx *AppContext) SetCookie(cookie *http.Cookie) {
 	ctx.HTTPServerContext.SetCookie(cookie)
 }
 
+// Context It returns the context of the underlying HTTP request. It is
+// cancelled when the client goes away and carries the request span
+func (ctx *AppContext) Context() context.Context {
+	return ctx.HTTPServerContext.Request().Context()
+}
+
 type RequestContext struct {
 	Body        interface{}
 	QueryParams QueryParams
+	PathParams  PathParams
 	Path        string
 	Headers     Headers
 }
@@ -59,6 +78,17 @@ type HTTPMethodHandler func(context AppContext, injectedServicesMap *InjectedSer
 type HTTPMethodHandlerConfig struct {
 	Handler   HTTPMethodHandler
 	JWTSecret string // If present then JWT middleware will be added on the handler
+	// JWT If present it takes precedence over JWTSecret
+	JWT *JWTConfig
+	// Roles If present the user must have at least one of them
+	Roles []string
+	// Permissions If present the user must have all of them
+	Permissions []string
+	// Guard It is a custom check run after the role and permission checks
+	Guard Guard
+	// Middlewares It is run after the module middlewares and the JWT
+	// middleware of the handler, in the given order
+	Middlewares []echo.MiddlewareFunc
 }
 type HTTPMethodConfig map[HTTPMethod]HTTPMethodHandlerConfig
 type Controllers map[RoutePath]HTTPMethodConfig
@@ -66,6 +96,18 @@ type ControllerConfig struct { // TODO: Rename this to moduleConfig
 	ModulePath  RoutePath
 	Controllers Controllers
 	JWTSecret   string // If present then JWT middleware will be added on the module
+	// JWT If present it takes precedence over JWTSecret
+	JWT *JWTConfig
+	// Roles If present the user must have at least one of them on every
+	// handler of the module. Checked before the handler level guards
+	Roles []string
+	// Permissions If present the user must have all of them on every handler
+	Permissions []string
+	// Guard It is a custom check run for every handler of the module
+	Guard Guard
+	// Middlewares It is run for every handler of the module and of its
+	// imports, after the JWT middleware of the module, in the given order
+	Middlewares []echo.MiddlewareFunc
 }
 
 type controllerDetails struct {
@@ -74,9 +116,14 @@ type controllerDetails struct {
 	c                   *ControllerConfig
 	injectedServicesMap *InjectedServicesMap
 	dbConfig            *database.DBConfig
-	redis               *redis.Redis
+	redis               *cache.Redis
 	smtpPool            *smtppool.Pool
+	otp                 *otp.Service
 	k                   *koanf.Koanf
+	metrics             *Metrics
+	// middlewares It holds the middlewares inherited from the parent
+	// modules followed by the ones of this module
+	middlewares []echo.MiddlewareFunc
 }
 
 // okResp It is a response struct for successful requests
@@ -86,9 +133,10 @@ type okResp struct {
 
 // errorResp It is a response struct for failed requests
 type errorResp struct {
-	Error      string `json:"error"`
-	Code       int    `json:"code"`
-	StatusText string `json:"statusText"`
+	Error      string       `json:"error"`
+	Code       int          `json:"code"`
+	StatusText string       `json:"statusText"`
+	Fields     []FieldError `json:"fields,omitempty"`
 }
 
 type HTTPError struct {
@@ -96,6 +144,8 @@ type HTTPError struct {
 	Code int
 	// Message It is the error message set by the caller
 	Message string
+	// Fields It lists the request fields that failed validation, if any
+	Fields []FieldError
 }
 
 func (e *HTTPError) Error() string {
@@ -113,9 +163,17 @@ func extractRequestContext(c echo.Context) RequestContext {
 	for key, value := range c.Request().Header {
 		headersMap[key] = value
 	}
+	pathParams := make(PathParams)
+	values := c.ParamValues()
+	for i, name := range c.ParamNames() {
+		if i < len(values) {
+			pathParams[name] = values[i]
+		}
+	}
 	return RequestContext{
 		Body:        c.Request().Body,
 		QueryParams: paramsMap,
+		PathParams:  pathParams,
 		Path:        c.Path(),
 		Headers:     headersMap,
 	}
@@ -131,23 +189,29 @@ func conditionalMiddleware(conditional bool, nextMiddleware echo.MiddlewareFunc)
 	}
 }
 
-func (cd *controllerDetails) handleIncomingRequest(c echo.Context, handler HTTPMethodHandler) error {
-	requestStart := time.Now().UnixNano()
-	fn := reflect.ValueOf(handler)
+func (cd *controllerDetails) handleIncomingRequest(c echo.Context, handlerConfig HTTPMethodHandlerConfig) error {
+	start := time.Now()
+	requestStart := start.UnixNano()
+	span := startRequestSpan(c, cd.c.ModulePath)
 	appContext := AppContext{
 		RequestContext:    extractRequestContext(c),
 		HTTPServerContext: c,
 		DBConfig:          cd.dbConfig,
 		Redis:             cd.redis,
 		SMTPPool:          cd.smtpPool,
+		OTP:               cd.otp,
 		K:                 cd.k,
 		Logger:            cd.l,
+		Services:          cd.injectedServicesMap,
+		Claims:            claimsFromContext(c),
+		authz:             &authorizationMemo{},
+	}
+	var data interface{}
+	controllerError := cd.checkGuards(appContext, handlerConfig)
+	if controllerError == nil {
+		data, controllerError = handlerConfig.Handler(appContext, cd.injectedServicesMap)
 	}
-	args := reflect.ValueOf(appContext)
-	serviceMap := reflect.ValueOf(cd.injectedServicesMap)
-	response := fn.Call([]reflect.Value{args, serviceMap})
-	data, e := response[0].Interface(), response[1].Interface()
-	if controllerError, ok := e.(*HTTPError); ok && controllerError != nil {
+	if controllerError != nil {
 		statusText := http.StatusText(controllerError.Code)
 		statusCode := controllerError.Code
 		if statusText == "" {
@@ -156,32 +220,52 @@ func (cd *controllerDetails) handleIncomingRequest(c echo.Context, handler HTTPM
 		}
 		cd.l.Error("Request failed", "path", c.Path(), "method", c.Request().Method, "code", statusCode)
 		cd.l.Info("Request time", "ms", (time.Now().UnixNano()-requestStart)/1000000)
+		endRequestSpan(c, span, cd.c.ModulePath, statusCode, controllerError.Message, start)
 		return c.JSON(statusCode, errorResp{
 			Error:      controllerError.Message,
 			Code:       statusCode,
 			StatusText: statusText,
+			Fields:     controllerError.Fields,
 		})
 	}
 	cd.l.Info("Request success", "path", c.Path(), "method", c.Request().Method)
 	cd.l.Info("Request time", "ms", (time.Now().UnixNano()-requestStart)/1000000)
+	endRequestSpan(c, span, cd.c.ModulePath, http.StatusOK, "", start)
 	return c.JSON(200, okResp{Data: data})
 }
 
-func (cd *controllerDetails) initIncomingRequestHandler(handler HTTPMethodHandler) func(echo.Context) error {
+func (cd *controllerDetails) initIncomingRequestHandler(handlerConfig HTTPMethodHandlerConfig) func(echo.Context) error {
 	return func(c echo.Context) error {
-		return cd.handleIncomingRequest(c, handler)
+		return cd.handleIncomingRequest(c, handlerConfig)
 	}
 }
 
+// checkGuards It runs the guards of the module followed by the ones of the handler
+func (cd *controllerDetails) checkGuards(ctx AppContext, handlerConfig HTTPMethodHandlerConfig) *HTTPError {
+	for _, g := range []guards{
+		{roles: cd.c.Roles, permissions: cd.c.Permissions, guard: cd.c.Guard},
+		{roles: handlerConfig.Roles, permissions: handlerConfig.Permissions, guard: handlerConfig.Guard},
+	} {
+		if g.empty() {
+			continue
+		}
+		if err := g.check(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (cd *controllerDetails) registerRoutes() {
 	moduleGroupRoute := cd.e.Group(string(cd.c.ModulePath))
-	if cd.c.JWTSecret != "" {
+	if moduleJWT := jwtConfigFor(cd.c.JWTSecret, cd.c.JWT); moduleJWT != nil {
 		// Add JWT middleware to the complete module
-		moduleGroupRoute.Use(echojwt.WithConfig(echojwt.Config{
-			SigningKey:    []byte(cd.c.JWTSecret),
-			SigningMethod: "HS256", // TODO: Make this configurable
-			TokenLookup:   "cookie:token",
-		}))
+		jwtMiddleware, err := moduleJWT.middleware(cd.redis)
+		if err != nil {
+			cd.l.Fatal("Invalid JWT config", "module", string(cd.c.ModulePath), "error", err)
+		}
+		moduleGroupRoute.Use(jwtMiddleware)
+		moduleGroupRoute.Use(cd.middlewares...)
 		methodFuncs := map[HTTPMethod]func(string, echo.HandlerFunc, ...echo.MiddlewareFunc) *echo.Route{
 			GET:     moduleGroupRoute.GET,
 			POST:    moduleGroupRoute.POST,
@@ -199,9 +283,10 @@ func (cd *controllerDetails) registerRoutes() {
 				pathToRegister = "/" + pathToRegister
 			}
 			for method, handlerConfig := range methodConfig {
-				initializedHandler := cd.initIncomingRequestHandler(handlerConfig.Handler)
+				initializedHandler := cd.initIncomingRequestHandler(handlerConfig)
 				if methodFunc, ok := methodFuncs[method]; ok {
-					methodFunc(pathToRegister, initializedHandler)
+					route := methodFunc(pathToRegister, initializedHandler, handlerConfig.Middlewares...)
+					cd.metrics.addRoute(route.Path, cd.c.ModulePath)
 					cd.l.Info("Registered restricted path", "Method", int(method), "Module", string(cd.c.ModulePath), "Path", pathToRegister)
 				} else {
 					cd.l.Error("Unsupported method", int(method))
@@ -235,20 +320,22 @@ func (cd *controllerDetails) registerRoutes() {
 				cd.l.Warn("A controller has no module path. It is recommended to always have a module path", "path", pathToRegister)
 			}
 			for method, handlerConfig := range methodConfig {
-				applyJWTMiddleware := handlerConfig.JWTSecret != ""
-				var middlewareFuncs []echo.MiddlewareFunc
+				handlerJWT := jwtConfigFor(handlerConfig.JWTSecret, handlerConfig.JWT)
+				applyJWTMiddleware := handlerJWT != nil
+				middlewareFuncs := append([]echo.MiddlewareFunc{}, cd.middlewares...)
 				if applyJWTMiddleware {
-					jwtMiddleware := echojwt.WithConfig(echojwt.Config{
-						SigningKey:    []byte(handlerConfig.JWTSecret),
-						SigningMethod: "HS256", // TODO: Make this configurable
-						TokenLookup:   "cookie:token",
-					})
+					jwtMiddleware, err := handlerJWT.middleware(cd.redis)
+					if err != nil {
+						cd.l.Fatal("Invalid JWT config", "path", pathToRegister, "error", err)
+					}
 					middlewareFuncs = append(middlewareFuncs, conditionalMiddleware(applyJWTMiddleware, jwtMiddleware))
 				}
-				initializedHandler := cd.initIncomingRequestHandler(handlerConfig.Handler)
+				middlewareFuncs = append(middlewareFuncs, handlerConfig.Middlewares...)
+				initializedHandler := cd.initIncomingRequestHandler(handlerConfig)
 				if methodFunc, ok := methodFuncs[method]; ok {
 					// Dynamically call the method function (e.g., GET, POST) with path, handler, and middleware
-					methodFunc(pathToRegister, initializedHandler, middlewareFuncs...)
+					route := methodFunc(pathToRegister, initializedHandler, middlewareFuncs...)
+					cd.metrics.addRoute(route.Path, cd.c.ModulePath)
 					cd.l.Info("Registered", "Method", int(method), "Path", pathToRegister)
 				} else {
 					cd.l.Error("Unsupported method", int(method))
@@ -0,0 +1,208 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	authorizationCacheKey = "TB:AUTHZ:%d"
+	authorizationCacheTTL = 5 * time.Minute
+)
+
+// Guard It is a custom authorization check run before the handler. Returning
+// a non nil HTTPError stops the request with that error
+type Guard func(ctx AppContext) *HTTPError
+
+// Authorization It holds the roles and permissions of the authenticated
+// user. It is resolved at most once per request
+type Authorization struct {
+	UserId      int64    `json:"userId"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// HasRole It tells if the user has the role
+func (a *Authorization) HasRole(role string) bool {
+	return slices.Contains(a.Roles, role)
+}
+
+// HasPermission It tells if the user has the permission through any of its roles
+func (a *Authorization) HasPermission(permission string) bool {
+	return slices.Contains(a.Permissions, permission)
+}
+
+// guards It groups the authorization requirements of a module or a route
+type guards struct {
+	roles       []string
+	permissions []string
+	guard       Guard
+}
+
+func (g guards) empty() bool {
+	return len(g.roles) == 0 && len(g.permissions) == 0 && g.guard == nil
+}
+
+// check It requires any of the roles and all of the permissions before
+// running the custom guard
+func (g guards) check(ctx AppContext) *HTTPError {
+	if len(g.roles) > 0 || len(g.permissions) > 0 {
+		authz, err := ctx.Authorization()
+		if err != nil {
+			return err
+		}
+		if len(g.roles) > 0 && !slices.ContainsFunc(g.roles, authz.HasRole) {
+			return &HTTPError{Code: http.StatusForbidden, Message: "Insufficient role"}
+		}
+		for _, p := range g.permissions {
+			if !authz.HasPermission(p) {
+				return &HTTPError{Code: http.StatusForbidden, Message: "Insufficient permissions"}
+			}
+		}
+	}
+	if g.guard != nil {
+		return g.guard(ctx)
+	}
+	return nil
+}
+
+// Authorization It returns the roles and permissions of the user identified
+// by the `sub` claim of the verified JWT. They are read from Redis when
+// cached there, otherwise from the database, and memoized for the request
+func (ctx *AppContext) Authorization() (*Authorization, *HTTPError) {
+	if ctx.authz != nil && ctx.authz.value != nil {
+		return ctx.authz.value, nil
+	}
+	if ctx.Claims == nil {
+		return nil, &HTTPError{Code: http.StatusUnauthorized, Message: "Authentication required"}
+	}
+	sub, err := ctx.Claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil, &HTTPError{Code: http.StatusUnauthorized, Message: "Token has no subject"}
+	}
+	userId, err := strconv.ParseInt(sub, 10, 64)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusUnauthorized, Message: "Token subject is not a user id"}
+	}
+
+	authz, err := loadAuthorization(ctx.Context(), ctx, userId)
+	if err != nil {
+		ctx.Logger.Error("Failed to resolve authorization", "userId", userId, "error", err)
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Failed to resolve authorization"}
+	}
+	if ctx.authz != nil {
+		ctx.authz.value = authz
+	}
+	return authz, nil
+}
+
+// authorizationMemo It is shared by the copies of an AppContext so that the
+// authorization is resolved once per request
+type authorizationMemo struct {
+	value *Authorization
+}
+
+func loadAuthorization(c context.Context, ctx *AppContext, userId int64) (*Authorization, error) {
+	key := fmt.Sprintf(authorizationCacheKey, userId)
+	if ctx.Redis != nil {
+		if b, err := ctx.Redis.Get(c, key); err == nil {
+			var authz Authorization
+			if err := json.Unmarshal(b, &authz); err == nil {
+				return &authz, nil
+			}
+		}
+	}
+
+	if ctx.DBConfig == nil {
+		return nil, fmt.Errorf("a database is required to resolve roles")
+	}
+	q := ctx.DBConfig.GetDefaultQueries()
+	authz := &Authorization{UserId: userId, Roles: []string{}, Permissions: []string{}}
+	if err := q.FetchUserRoles.SelectContext(c, &authz.Roles, userId); err != nil {
+		return nil, err
+	}
+	if err := q.FetchUserPermissions.SelectContext(c, &authz.Permissions, userId); err != nil {
+		return nil, err
+	}
+
+	if ctx.Redis != nil {
+		if b, err := json.Marshal(authz); err == nil {
+			if err := ctx.Redis.Set(c, key, b, authorizationCacheTTL); err != nil {
+				ctx.Logger.Warn("Failed to cache authorization", "userId", userId, "error", err)
+			}
+		}
+	}
+	return authz, nil
+}
+
+// InvalidateAuthorization It drops the cached roles and permissions of the
+// user. It has to be called whenever they change outside of AssignRole,
+// RemoveRole, GrantPermission and RevokePermission
+func InvalidateAuthorization(c context.Context, r *cache.Redis, userId int64) error {
+	if r == nil {
+		return nil
+	}
+	return r.Delete(c, fmt.Sprintf(authorizationCacheKey, userId))
+}
+
+// AssignRole It gives the role to the user and drops the cached
+// authorization of the user. Roles that do not exist are ignored
+func AssignRole(c context.Context, dbConfig *database.DBConfig, r *cache.Redis, userId int64, role string) error {
+	if _, err := dbConfig.GetDefaultQueries().AssignUserRole.ExecContext(c, userId, role); err != nil {
+		return err
+	}
+	return InvalidateAuthorization(c, r, userId)
+}
+
+// RemoveRole It takes the role away from the user and drops the cached
+// authorization of the user
+func RemoveRole(c context.Context, dbConfig *database.DBConfig, r *cache.Redis, userId int64, role string) error {
+	if _, err := dbConfig.GetDefaultQueries().RemoveUserRole.ExecContext(c, userId, role); err != nil {
+		return err
+	}
+	return InvalidateAuthorization(c, r, userId)
+}
+
+// GrantPermission It gives the permission to the role and drops the cached
+// authorization of every user having the role
+func GrantPermission(c context.Context, dbConfig *database.DBConfig, r *cache.Redis, role, permission string) error {
+	if _, err := dbConfig.GetDefaultQueries().GrantRolePermission.ExecContext(c, role, permission); err != nil {
+		return err
+	}
+	return invalidateRole(c, dbConfig, r, role)
+}
+
+// RevokePermission It takes the permission away from the role and drops the
+// cached authorization of every user having the role
+func RevokePermission(c context.Context, dbConfig *database.DBConfig, r *cache.Redis, role, permission string) error {
+	if _, err := dbConfig.GetDefaultQueries().RevokeRolePermission.ExecContext(c, role, permission); err != nil {
+		return err
+	}
+	return invalidateRole(c, dbConfig, r, role)
+}
+
+// invalidateRole It drops the cached authorization of the users having the role
+func invalidateRole(c context.Context, dbConfig *database.DBConfig, r *cache.Redis, role string) error {
+	if r == nil {
+		return nil
+	}
+	var userIds []int64
+	if err := dbConfig.GetDefaultQueries().FetchRoleUsers.SelectContext(c, &userIds, role); err != nil {
+		return err
+	}
+	if len(userIds) == 0 {
+		return nil
+	}
+	keys := make([]string, len(userIds))
+	for i, id := range userIds {
+		keys[i] = fmt.Sprintf(authorizationCacheKey, id)
+	}
+	return r.Delete(c, keys...)
+}
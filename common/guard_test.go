@@ -0,0 +1,81 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"github.com/suhailgupta03/thunderbyte/database/dbtest"
+	"github.com/zerodha/logf"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAuthorizationInvalidation(t *testing.T) {
+	dbc := dbtest.Connect(t)
+	mr := miniredis.RunT(t)
+	r := cache.New(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { _ = r.Close() })
+
+	c := context.Background()
+	db := dbc.GetDB()
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	role, permission := "role-"+suffix, "permission-"+suffix
+	var userId int64
+	if err := db.QueryRowContext(c, "INSERT INTO auth_users (username) VALUES ($1) RETURNING id", "user-"+suffix).Scan(&userId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(c, "INSERT INTO auth_roles (name) VALUES ($1)", role); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(c, "INSERT INTO auth_permissions (name) VALUES ($1)", permission); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = db.Exec("DELETE FROM auth_users where id = $1", userId)
+		_, _ = db.Exec("DELETE FROM auth_roles where name = $1", role)
+		_, _ = db.Exec("DELETE FROM auth_permissions where name = $1", permission)
+	})
+
+	l := logf.New(logf.Opts{Level: logf.WarnLevel})
+	ctx := &AppContext{DBConfig: dbc, Redis: r, Logger: &l}
+	authorization := func() *Authorization {
+		t.Helper()
+		authz, err := loadAuthorization(c, ctx, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return authz
+	}
+	// Every lookup below follows a change, so it would be stale if the
+	// change left the cached authorization in place
+	if authz := authorization(); len(authz.Roles) != 0 {
+		t.Fatalf("new user has roles %v", authz.Roles)
+	}
+
+	tests := []struct {
+		name           string
+		change         func() error
+		wantRole       bool
+		wantPermission bool
+	}{
+		{"assign role", func() error { return AssignRole(c, dbc, r, userId, role) }, true, false},
+		{"grant permission", func() error { return GrantPermission(c, dbc, r, role, permission) }, true, true},
+		{"revoke permission", func() error { return RevokePermission(c, dbc, r, role, permission) }, true, false},
+		{"remove role", func() error { return RemoveRole(c, dbc, r, userId, role) }, false, false},
+	}
+	for _, tt := range tests {
+		if err := tt.change(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		authz := authorization()
+		if got := slices.Contains(authz.Roles, role); got != tt.wantRole {
+			t.Errorf("after %s has role = %v, want %v", tt.name, got, tt.wantRole)
+		}
+		if got := slices.Contains(authz.Permissions, permission); got != tt.wantPermission {
+			t.Errorf("after %s has permission = %v, want %v", tt.name, got, tt.wantPermission)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// FieldError It describes a request field that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Message string `json:"message"`
+}
+
+// TypedHandler It is a handler that receives the request already bound
+// and validated into Req
+type TypedHandler[Req, Resp any] func(ctx AppContext, req Req) (Resp, *HTTPError)
+
+// Handle It adapts a TypedHandler into an HTTPMethodHandler. Path params
+// (`param` tag), query params (`query` tag) and the JSON or form body
+// (`json` / `form` tags) are bound into Req, which is then validated with
+// the server's validator. Binding and validation failures are returned as
+// a 400 listing the offending fields. Injected services are available
+// through ctx.Services
+func Handle[Req, Resp any](fn TypedHandler[Req, Resp]) HTTPMethodHandler {
+	return func(ctx AppContext, _ *InjectedServicesMap) (interface{}, *HTTPError) {
+		var req Req
+		c := ctx.HTTPServerContext
+		binder := &echo.DefaultBinder{}
+		if err := binder.BindPathParams(c, &req); err != nil {
+			return nil, bindError(err)
+		}
+		if err := binder.BindQueryParams(c, &req); err != nil {
+			return nil, bindError(err)
+		}
+		if err := binder.BindBody(c, &req); err != nil {
+			return nil, bindError(err)
+		}
+		if v := c.Echo().Validator; v != nil {
+			if err := v.Validate(req); err != nil {
+				return nil, validationError(err)
+			}
+		}
+		return fn(ctx, req)
+	}
+}
+
+// bindError It converts an error raised by the echo binder into a 400
+func bindError(err error) *HTTPError {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return &HTTPError{Code: http.StatusBadRequest, Message: fmt.Sprint(he.Message)}
+	}
+	return &HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+}
+
+// validationError It converts validator errors into a 400 with field level details
+func validationError(err error) *HTTPError {
+	var ve validator.ValidationErrors
+	if !errors.As(err, &ve) {
+		return &HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	fields := make([]FieldError, 0, len(ve))
+	for _, fe := range ve {
+		fields = append(fields, FieldError{
+			Field:   fe.Field(),
+			Tag:     fe.Tag(),
+			Message: fmt.Sprintf("%s failed on the '%s' validation", fe.Field(), fe.Tag()),
+		})
+	}
+	return &HTTPError{Code: http.StatusBadRequest, Message: "Request validation failed", Fields: fields}
+}
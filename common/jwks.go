@@ -0,0 +1,201 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/sync/singleflight"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSRefreshInterval = time.Hour
+	// minJWKSRefreshInterval It limits the reloads triggered by unknown key
+	// IDs and the retries after a failed reload
+	minJWKSRefreshInterval = time.Minute
+)
+
+// jwk It is a single JSON Web Key. Only the public members are read
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwks It is a JSON Web Key Set loaded from a file or a URL and
+// reloaded periodically to pick up rotated keys
+type jwks struct {
+	file     string
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	// group It makes concurrent requests share a single reload
+	group singleflight.Group
+
+	mu       sync.RWMutex
+	keys     map[string]interface{}
+	loadedAt time.Time
+	// lastAttempt It is when the last reload started, whether it succeeded
+	// or not
+	lastAttempt time.Time
+}
+
+func newJWKS(file, url string, interval time.Duration) *jwks {
+	if interval == 0 {
+		interval = defaultJWKSRefreshInterval
+	}
+	return &jwks{
+		file:     file,
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// key It returns the key with the given ID, reloading the set when it
+// is stale or when the ID is unknown. A failed reload is not retried
+// before minJWKSRefreshInterval, meanwhile the keys already loaded are used
+func (s *jwks) key(kid string) (interface{}, error) {
+	s.mu.RLock()
+	k, ok := s.keys[kid]
+	stale := time.Since(s.loadedAt) >= s.interval
+	retryAt := s.lastAttempt.Add(min(s.interval, minJWKSRefreshInterval))
+	s.mu.RUnlock()
+
+	if (!ok || stale) && time.Now().After(retryAt) {
+		_, err, _ := s.group.Do("refresh", func() (interface{}, error) {
+			return nil, s.refresh()
+		})
+		if err != nil && !ok {
+			return nil, err
+		}
+		s.mu.RLock()
+		k, ok = s.keys[kid]
+		s.mu.RUnlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id '%s'", kid)
+	}
+	return k, nil
+}
+
+// refresh It reloads the key set from its source
+func (s *jwks) refresh() error {
+	s.mu.Lock()
+	s.lastAttempt = time.Now()
+	s.mu.Unlock()
+
+	b, err := s.read()
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			return fmt.Errorf("invalid JWKS key '%s': %w", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *jwks) read() ([]byte, error) {
+	if s.file != "" {
+		return os.ReadFile(s.file)
+	}
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS failed with status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// publicKey It converts the JWK into an RSA, ECDSA or Ed25519 public key
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
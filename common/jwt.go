@@ -0,0 +1,229 @@
+package common
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	echojwt "github.com/labstack/echo-jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"os"
+	"time"
+)
+
+const (
+	// TokenLookupCookie It reads the token from the `token` cookie
+	TokenLookupCookie = "cookie:token"
+	// TokenLookupBearer It reads the token from the Authorization header
+	TokenLookupBearer = "header:Authorization:Bearer "
+	// jwtContextKey It is the key under which echojwt stores the token
+	jwtContextKey = "user"
+)
+
+// JWTConfig It configures how tokens are verified on a module or a handler
+type JWTConfig struct {
+	// SigningMethods It lists the accepted algorithms, e.g. HS256, RS256,
+	// ES256 or EdDSA. Tokens signed with any other algorithm are rejected.
+	// Defaults to HS256
+	SigningMethods []string
+	// Secret It is the key used for the HMAC (HS*) algorithms
+	Secret string
+	// PublicKeyPEM It is a PEM encoded public key or certificate used for
+	// the asymmetric algorithms when no JWKS is configured
+	PublicKeyPEM string
+	// PublicKeyFile It is the path of a PEM file, an alternative to PublicKeyPEM
+	PublicKeyFile string
+	// JWKSFile It is the path of a JSON Web Key Set. Keys are picked by the
+	// `kid` header of the token
+	JWKSFile string
+	// JWKSURL It is the URL serving a JSON Web Key Set
+	JWKSURL string
+	// JWKSRefreshInterval It is how often the key set is reloaded to pick up
+	// rotated keys. A token with an unknown `kid` also triggers a reload.
+	// Defaults to 1 hour
+	JWKSRefreshInterval time.Duration
+	// TokenLookup It follows the echojwt syntax. Several sources can be
+	// separated by commas, e.g. TokenLookupBearer + "," + TokenLookupCookie.
+	// Defaults to TokenLookupCookie
+	TokenLookup string
+	// Issuer If set the `iss` claim must match it
+	Issuer string
+	// Audience If set the `aud` claim must contain it
+	Audience string
+	// Leeway It is the clock skew tolerated while checking exp, nbf and iat
+	Leeway time.Duration
+	// NewClaims It returns the value the claims are decoded into. Defaults
+	// to a *Claims
+	NewClaims func() jwt.Claims
+	// CheckRevocation It rejects the tokens whose `sid` claim was revoked
+	// with RevokeSession. It requires Redis
+	CheckRevocation bool
+}
+
+// Claims It is the default set of claims surfaced on AppContext
+type Claims struct {
+	jwt.RegisteredClaims
+	// SessionID It identifies the session the token was issued for
+	SessionID string `json:"sid,omitempty"`
+}
+
+// GetSessionID It returns the `sid` claim
+func (c *Claims) GetSessionID() string {
+	return c.SessionID
+}
+
+// jwtConfigFor It returns the config to be used for a module or a handler.
+// JWTSecret is kept for backward compatibility and is equivalent to a
+// JWTConfig holding only the secret
+func jwtConfigFor(secret string, cfg *JWTConfig) *JWTConfig {
+	if cfg != nil {
+		return cfg
+	}
+	if secret != "" {
+		return &JWTConfig{Secret: secret}
+	}
+	return nil
+}
+
+// middleware It builds the echojwt middleware for the config. r is used
+// for the revocation checks
+func (jc *JWTConfig) middleware(r *cache.Redis) (echo.MiddlewareFunc, error) {
+	if jc.CheckRevocation && r == nil {
+		return nil, errors.New("JWT revocation checks require Redis")
+	}
+	keyFunc, err := jc.keyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	methods := jc.SigningMethods
+	if len(methods) == 0 {
+		methods = []string{"HS256"}
+	}
+	for _, m := range methods {
+		if jwt.GetSigningMethod(m) == nil {
+			return nil, fmt.Errorf("unsupported JWT signing method '%s'", m)
+		}
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithLeeway(jc.Leeway)}
+	if jc.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(jc.Issuer))
+	}
+	if jc.Audience != "" {
+		opts = append(opts, jwt.WithAudience(jc.Audience))
+	}
+	parser := jwt.NewParser(opts...)
+
+	newClaims := jc.NewClaims
+	if newClaims == nil {
+		newClaims = func() jwt.Claims { return &Claims{} }
+	}
+	lookup := jc.TokenLookup
+	if lookup == "" {
+		lookup = TokenLookupCookie
+	}
+
+	return echojwt.WithConfig(echojwt.Config{
+		ContextKey:  jwtContextKey,
+		TokenLookup: lookup,
+		ParseTokenFunc: func(c echo.Context, auth string) (interface{}, error) {
+			token, err := parser.ParseWithClaims(auth, newClaims(), keyFunc)
+			if err != nil {
+				return nil, err
+			}
+			if jc.CheckRevocation {
+				revoked, err := isRevoked(c.Request().Context(), r, token.Claims)
+				if err != nil {
+					return nil, err
+				}
+				if revoked {
+					return nil, ErrTokenRevoked
+				}
+			}
+			return token, nil
+		},
+	}), nil
+}
+
+// keyFunc It resolves the verification key of a token based on its algorithm
+func (jc *JWTConfig) keyFunc() (jwt.Keyfunc, error) {
+	var (
+		keys      *jwks
+		publicKey interface{}
+	)
+	switch {
+	case jc.JWKSFile != "" || jc.JWKSURL != "":
+		keys = newJWKS(jc.JWKSFile, jc.JWKSURL, jc.JWKSRefreshInterval)
+		if err := keys.refresh(); err != nil {
+			return nil, err
+		}
+	case jc.PublicKeyPEM != "" || jc.PublicKeyFile != "":
+		pemBytes := []byte(jc.PublicKeyPEM)
+		if jc.PublicKeyFile != "" {
+			b, err := os.ReadFile(jc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pemBytes = b
+		}
+		k, err := parsePublicKeyPEM(pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = k
+	}
+
+	return func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			if jc.Secret == "" {
+				return nil, errors.New("no secret configured for HMAC tokens")
+			}
+			return []byte(jc.Secret), nil
+		}
+		if keys != nil {
+			kid, _ := t.Header["kid"].(string)
+			return keys.key(kid)
+		}
+		if publicKey != nil {
+			return publicKey, nil
+		}
+		return nil, fmt.Errorf("no key configured for %s tokens", t.Method.Alg())
+	}, nil
+}
+
+// parsePublicKeyPEM It parses a PKIX or PKCS1 public key or a certificate
+func parsePublicKeyPEM(b []byte) (interface{}, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("invalid PEM public key")
+	}
+	if k, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.New("PEM block is neither a public key nor a certificate")
+	}
+	return cert.PublicKey, nil
+}
+
+// ClaimsAs It returns the claims of the verified token as T, e.g.
+// ClaimsAs[*common.Claims](ctx). The second value is false when the
+// request carries no token or the claims are of another type
+func ClaimsAs[T jwt.Claims](ctx AppContext) (T, bool) {
+	claims, ok := ctx.Claims.(T)
+	return claims, ok
+}
+
+// claimsFromContext It returns the claims set by the JWT middleware, if any
+func claimsFromContext(c echo.Context) jwt.Claims {
+	if token, ok := c.Get(jwtContextKey).(*jwt.Token); ok {
+		return token.Claims
+	}
+	return nil
+}
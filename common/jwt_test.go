@@ -0,0 +1,294 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// verify It returns the status of a request carrying the token to a route
+// protected by the config
+func verify(t *testing.T, jc *JWTConfig, token string) int {
+	t.Helper()
+	mw, err := jc.middleware(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, mw)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func validClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func rsaJWK(kid string, k *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+	}
+}
+
+// jwksServer It serves the keys currently stored in it, or fails with a 500
+// when fail is set
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []jwk
+	fail     atomic.Bool
+	requests atomic.Int32
+}
+
+func newJWKSServer(t *testing.T, keys ...jwk) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		if s.fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string][]jwk{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...jwk) {
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+func TestJWTAlgorithmPinning(t *testing.T) {
+	key := newRSAKey(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	jc := &JWTConfig{SigningMethods: []string{"RS256"}, PublicKeyPEM: pemKey, Secret: "secret", TokenLookup: TokenLookupBearer}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"pinned algorithm", sign(t, jwt.SigningMethodRS256, key, "", validClaims()), http.StatusOK},
+		{"other RSA algorithm", sign(t, jwt.SigningMethodRS512, key, "", validClaims()), http.StatusUnauthorized},
+		{"HMAC with the secret", sign(t, jwt.SigningMethodHS256, []byte("secret"), "", validClaims()), http.StatusUnauthorized},
+		{"HMAC keyed with the public key", sign(t, jwt.SigningMethodHS256, []byte(pemKey), "", validClaims()), http.StatusUnauthorized},
+		{"none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", validClaims()), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := verify(t, jc, tt.token); got != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := (&JWTConfig{SigningMethods: []string{"XX256"}}).middleware(nil); err == nil {
+		t.Error("an unknown signing method was accepted")
+	}
+	if _, err := (&JWTConfig{CheckRevocation: true}).middleware(nil); err == nil {
+		t.Error("revocation checks were accepted without Redis")
+	}
+}
+
+func TestJWTClaimsValidation(t *testing.T) {
+	secret := []byte("secret")
+	jc := &JWTConfig{Secret: "secret", Issuer: "tb", Audience: "api", Leeway: 10 * time.Second, TokenLookup: TokenLookupBearer}
+	claims := func(iss, aud string, exp time.Duration) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Issuer:    iss,
+			Audience:  jwt.ClaimStrings{aud},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(exp)),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.RegisteredClaims
+		want   int
+	}{
+		{"valid", claims("tb", "api", time.Minute), http.StatusOK},
+		{"wrong issuer", claims("other", "api", time.Minute), http.StatusUnauthorized},
+		{"wrong audience", claims("tb", "web", time.Minute), http.StatusUnauthorized},
+		{"expired within the leeway", claims("tb", "api", -5*time.Second), http.StatusOK},
+		{"expired beyond the leeway", claims("tb", "api", -time.Minute), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verify(t, jc, sign(t, jwt.SigningMethodHS256, secret, "", tt.claims)); got != tt.want {
+				t.Errorf("got status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePublicKeyPEM(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tb"},
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "tb"}}, &rsaKey.PublicKey, rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		block   *pem.Block
+		wantErr bool
+	}{
+		{"PKIX", &pem.Block{Type: "PUBLIC KEY", Bytes: ecDER}, false},
+		{"PKCS1", &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)}, false},
+		{"certificate", &pem.Block{Type: "CERTIFICATE", Bytes: cert}, false},
+		{"garbage", &pem.Block{Type: "PUBLIC KEY", Bytes: []byte("garbage")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePublicKeyPEM(pem.EncodeToMemory(tt.block))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePublicKeyPEM() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := parsePublicKeyPEM([]byte("not PEM")); err == nil {
+		t.Error("parsePublicKeyPEM accepted a non PEM input")
+	}
+
+	jc := &JWTConfig{SigningMethods: []string{"ES256"}, PublicKeyPEM: string(pem.EncodeToMemory(tests[0].block)), TokenLookup: TokenLookupBearer}
+	if got := verify(t, jc, sign(t, jwt.SigningMethodES256, ecKey, "", validClaims())); got != http.StatusOK {
+		t.Errorf("ES256 token signed with the PEM key got status %d", got)
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	srv := newJWKSServer(t, rsaJWK("old", &oldKey.PublicKey))
+	jc := &JWTConfig{SigningMethods: []string{"RS256"}, JWKSURL: srv.URL, TokenLookup: TokenLookupBearer}
+	keys := newJWKS("", srv.URL, 0)
+	if err := keys.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keys.key("old"); err != nil {
+		t.Fatal(err)
+	}
+	srv.setKeys(rsaJWK("old", &oldKey.PublicKey), rsaJWK("new", &newKey.PublicKey))
+
+	// The unknown key ID is not looked up again right after a reload.
+	if _, err := keys.key("new"); err == nil {
+		t.Fatal("the key set was reloaded within the minimum refresh interval")
+	}
+	keys.lastAttempt = time.Now().Add(-minJWKSRefreshInterval)
+	k, err := keys.key("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newKey.PublicKey.Equal(k) {
+		t.Error("got the wrong key for the rotated key ID")
+	}
+
+	if got := verify(t, jc, sign(t, jwt.SigningMethodRS256, oldKey, "old", validClaims())); got != http.StatusOK {
+		t.Errorf("token signed with the old key got status %d", got)
+	}
+	if got := verify(t, jc, sign(t, jwt.SigningMethodRS256, oldKey, "new", validClaims())); got != http.StatusUnauthorized {
+		t.Errorf("token signed with the wrong key got status %d", got)
+	}
+}
+
+func TestJWKSFailingSource(t *testing.T) {
+	key := newRSAKey(t)
+	srv := newJWKSServer(t, rsaJWK("k1", &key.PublicKey))
+	keys := newJWKS("", srv.URL, time.Hour)
+	if err := keys.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The set is stale and its source fails: the loaded keys keep being
+	// served and the source is hit only once per interval.
+	srv.fail.Store(true)
+	keys.loadedAt = time.Now().Add(-2 * time.Hour)
+	keys.lastAttempt = keys.loadedAt
+	before := srv.requests.Load()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := keys.key("k1"); err != nil {
+				t.Error(err)
+			}
+			if _, err := keys.key("unknown"); err == nil {
+				t.Error("an unknown key ID was accepted")
+			}
+		}()
+	}
+	wg.Wait()
+	if got := srv.requests.Load() - before; got != 1 {
+		t.Errorf("the failing source got %d requests, want 1", got)
+	}
+
+	if _, err := newJWKS("", srv.URL, 0).key("k1"); err == nil {
+		t.Error("a key was returned while the source fails")
+	}
+	if _, err := (&JWTConfig{JWKSURL: srv.URL}).keyFunc(); err == nil {
+		t.Error("the middleware was built while the source fails")
+	}
+}
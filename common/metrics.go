@@ -0,0 +1,121 @@
+package common
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// unmatchedRoute It is the route label of the requests that matched no route,
+// so that scanners can't create a series per URL
+const unmatchedRoute = "unmatched"
+
+// Metrics It holds the Prometheus collectors updated for every request
+// served by the app
+type Metrics struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	// modules It maps the routes registered by the controllers to the path
+	// of their module
+	modules sync.Map
+}
+
+// NewMetrics It creates the request collectors and registers them on reg.
+// Apps sharing reg share the collectors
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	labels := []string{"module", "route", "method"}
+	return &Metrics{
+		requests: RegisterShared(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "thunderbyte",
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Total number of requests served by the app.",
+		}, append(labels, "code"))),
+		errors: RegisterShared(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "thunderbyte",
+			Subsystem: "http",
+			Name:      "request_errors_total",
+			Help:      "Total number of requests answered with a 4xx or 5xx status.",
+		}, append(labels, "code"))),
+		latency: RegisterShared(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "thunderbyte",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to serve a request.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels)),
+	}
+}
+
+// RegisterShared It registers c on reg. If an equal collector is already
+// registered, e.g. by another app sharing reg, the existing one is returned
+// instead of panicking
+func RegisterShared[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
+
+// Middleware It records every request, including the ones rejected by the
+// JWT or other middlewares and the ones matching no route. It should be
+// added with echo.Use so that the route is known when it runs
+func (m *Metrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			m.observe(c, err, start)
+			return err
+		}
+	}
+}
+
+// addRoute It records the module of a route registered by a controller. It is
+// safe to call on a nil Metrics
+func (m *Metrics) addRoute(route string, modulePath RoutePath) {
+	if m == nil {
+		return
+	}
+	m.modules.Store(route, modulePath)
+}
+
+// observe It records a served request. err is the one returned by the
+// handler chain, which echo has not written to the response yet
+func (m *Metrics) observe(c echo.Context, err error, start time.Time) {
+	statusCode := c.Response().Status
+	if err != nil && !c.Response().Committed {
+		statusCode = http.StatusInternalServerError
+		var he *echo.HTTPError
+		if errors.As(err, &he) {
+			statusCode = he.Code
+		}
+	}
+
+	route := c.Path()
+	var modulePath RoutePath
+	if route == "" {
+		route = unmatchedRoute
+	} else if p, ok := m.modules.Load(route); ok {
+		modulePath = p.(RoutePath)
+	}
+
+	method := c.Request().Method
+	code := strconv.Itoa(statusCode)
+	m.requests.WithLabelValues(string(modulePath), route, method, code).Inc()
+	if statusCode >= http.StatusBadRequest {
+		m.errors.WithLabelValues(string(modulePath), route, method, code).Inc()
+	}
+	m.latency.WithLabelValues(string(modulePath), route, method).Observe(time.Since(start).Seconds())
+}
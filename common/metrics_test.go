@@ -0,0 +1,53 @@
+package common
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsSharedRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, b := NewMetrics(reg), NewMetrics(reg)
+	if a.requests != b.requests || a.latency != b.latency {
+		t.Error("apps sharing a registry got different collectors")
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry())
+	e := echo.New()
+	e.Use(m.Middleware())
+	reject := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return echo.ErrUnauthorized
+		}
+	}
+	ok := func(c echo.Context) error { return c.String(http.StatusOK, "ok") }
+	m.addRoute(e.GET("/users/open", ok).Path, "/users")
+	m.addRoute(e.GET("/users/closed", ok, reject).Path, "/users")
+
+	for _, target := range []string{"/users/open", "/users/closed", "/nope/1", "/nope/2"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	tests := []struct {
+		module, route, code string
+		want                float64
+	}{
+		{"/users", "/users/open", "200", 1},
+		{"/users", "/users/closed", "401", 1},
+		{"", unmatchedRoute, "404", 2},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(m.requests.WithLabelValues(tt.module, tt.route, http.MethodGet, tt.code)); got != tt.want {
+			t.Errorf("requests{%s %s %s} = %v, want %v", tt.module, tt.route, tt.code, got, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(m.errors.WithLabelValues("", unmatchedRoute, http.MethodGet, "404")); got != 2 {
+		t.Errorf("unmatched errors = %v, want 2", got)
+	}
+}
@@ -1,12 +1,12 @@
 package common
 
 import (
-	"fmt"
 	"github.com/knadh/koanf/v2"
 	"github.com/labstack/echo/v4"
 	"github.com/suhailgupta03/smtppool"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
 	"github.com/suhailgupta03/thunderbyte/database"
-	"github.com/suhailgupta03/thunderbyte/otp/store/redis"
+	"github.com/suhailgupta03/thunderbyte/otp"
 	"github.com/zerodha/logf"
 	"path"
 	"reflect"
@@ -18,64 +18,94 @@ type Module struct {
 	ControllerConfig *ControllerConfig
 	Providers        []interface{}
 	Imports          []*Module
+	// Init If set it is called with the services of the app before the
+	// routes of the module are registered. An error stops the app
+	Init func(p InitModuleParams) error
 }
 
 type InitModuleParams struct {
 	Srv      *echo.Echo
 	DBConfig *database.DBConfig
-	Redis    *redis.Redis
+	Redis    *cache.Redis
 	K        *koanf.Koanf
 	SMTPPool *smtppool.Pool
-	Logger   *logf.Logger
+	// OTP It sets and verifies codes with the providers initialized at startup
+	OTP    *otp.Service
+	Logger *logf.Logger
+	// Metrics If present the requests of every module are labelled with its path
+	Metrics *Metrics
+	// inheritedMiddlewares It is set while initializing the imports of a
+	// module and holds the middlewares of all its ancestors
+	inheritedMiddlewares []echo.MiddlewareFunc
 }
 
-// InitModule It initializes the module by registering routes
+// InitModule It initializes the module by registering routes. The modules
+// passed in are not modified, so the same module can be registered on
+// more than one server
 func InitModule(modules []*Module, moduleParams *InitModuleParams, basePath *string) {
 	logger := moduleParams.Logger
 	srv := moduleParams.Srv
 
 	for _, module := range modules {
 		if module != nil {
-			fmt.Println(module.ControllerConfig.ModulePath)
+			if module.ControllerConfig == nil {
+				logger.Fatal("ControllerConfig is required")
+			}
 			if module.ControllerConfig.ModulePath == "" {
 				logger.Fatal("ModulePath is missing for one of the controller configs in imports")
 			}
-			if module.E == nil {
-				module.E = srv
+			if module.Init != nil {
+				if err := module.Init(*moduleParams); err != nil {
+					logger.Fatal("Failed to initialize module", "path", module.ControllerConfig.ModulePath, "error", err)
+				}
 			}
-			if module.L == nil {
-				module.L = logger
+			e := module.E
+			if e == nil {
+				e = srv
 			}
-			if module.ControllerConfig == nil {
-				logger.Fatal("ControllerConfig is required")
+			l := module.L
+			if l == nil {
+				l = logger
 			}
+			// Work on a copy so that nesting the module under a base path
+			// does not leak into other servers sharing the same config
+			controllerConfig := *module.ControllerConfig
 			if basePath != nil {
-				module.ControllerConfig.ModulePath = RoutePath(path.Join(*basePath, string(module.ControllerConfig.ModulePath)))
+				controllerConfig.ModulePath = RoutePath(path.Join(*basePath, string(controllerConfig.ModulePath)))
 			}
 			// Create a map of services to be injected
 			// into the controller
+			// Middlewares of the ancestors run before the ones of the module
+			middlewares := make([]echo.MiddlewareFunc, 0, len(moduleParams.inheritedMiddlewares)+len(controllerConfig.Middlewares))
+			middlewares = append(middlewares, moduleParams.inheritedMiddlewares...)
+			middlewares = append(middlewares, controllerConfig.Middlewares...)
 			serviceMap := make(InjectedServicesMap)
 			for _, p := range module.Providers {
 				ssType := reflect.TypeOf(p)
 				serviceMap[ServiceName(ssType.Name())] = p
 			}
 			cd := controllerDetails{
-				l:                   module.L,
-				e:                   module.E,
-				c:                   module.ControllerConfig,
+				l:                   l,
+				e:                   e,
+				c:                   &controllerConfig,
 				injectedServicesMap: &serviceMap,
 				dbConfig:            moduleParams.DBConfig,
 				redis:               moduleParams.Redis,
 				smtpPool:            moduleParams.SMTPPool,
+				otp:                 moduleParams.OTP,
 				k:                   moduleParams.K,
+				metrics:             moduleParams.Metrics,
+				middlewares:         middlewares,
 			}
-			logger.Info("Initializing module", "path", module.ControllerConfig.ModulePath)
+			logger.Info("Initializing module", "path", controllerConfig.ModulePath)
 			cd.registerRoutes()
 			if len(module.Imports) > 0 {
 				// Recursively initialize the imports
 				// Does the nesting of routes
-				newBasePath := string(module.ControllerConfig.ModulePath)
-				InitModule(module.Imports, moduleParams, &newBasePath)
+				newBasePath := string(controllerConfig.ModulePath)
+				childParams := *moduleParams
+				childParams.inheritedMiddlewares = middlewares
+				InitModule(module.Imports, &childParams, &newBasePath)
 			}
 		}
 	}
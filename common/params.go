@@ -0,0 +1,64 @@
+package common
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+)
+
+// PathParams It holds the route parameters of the request, e.g.
+// {"id": "42"} for a controller registered at /users/:id
+type PathParams map[string]string
+
+// String It returns the named path param. A 400 HTTPError is returned
+// when the param is missing or empty
+func (p PathParams) String(name string) (string, *HTTPError) {
+	v, ok := p[name]
+	if !ok || v == "" {
+		return "", &HTTPError{Code: http.StatusBadRequest, Message: fmt.Sprintf("path param '%s' is required", name)}
+	}
+	return v, nil
+}
+
+// Int It returns the named path param parsed as an int. A 400 HTTPError
+// is returned when the param is missing or not an integer
+func (p PathParams) Int(name string) (int, *HTTPError) {
+	v, err := p.String(name)
+	if err != nil {
+		return 0, err
+	}
+	i, perr := strconv.Atoi(v)
+	if perr != nil {
+		return 0, &HTTPError{Code: http.StatusBadRequest, Message: fmt.Sprintf("path param '%s' must be an integer", name)}
+	}
+	return i, nil
+}
+
+// Int64 It returns the named path param parsed as an int64. A 400
+// HTTPError is returned when the param is missing or not an integer
+func (p PathParams) Int64(name string) (int64, *HTTPError) {
+	v, err := p.String(name)
+	if err != nil {
+		return 0, err
+	}
+	i, perr := strconv.ParseInt(v, 10, 64)
+	if perr != nil {
+		return 0, &HTTPError{Code: http.StatusBadRequest, Message: fmt.Sprintf("path param '%s' must be an integer", name)}
+	}
+	return i, nil
+}
+
+// UUID It returns the named path param parsed as a UUID. A 400 HTTPError
+// is returned when the param is missing or not a valid UUID
+func (p PathParams) UUID(name string) (uuid.UUID, *HTTPError) {
+	v, err := p.String(name)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	id, perr := uuid.Parse(v)
+	if perr != nil {
+		return uuid.Nil, &HTTPError{Code: http.StatusBadRequest, Message: fmt.Sprintf("path param '%s' must be a UUID", name)}
+	}
+	return id, nil
+}
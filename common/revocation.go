@@ -0,0 +1,41 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"time"
+)
+
+const revokedSessionKey = "TB:REVOKED:SID:%s"
+
+// ErrTokenRevoked It is returned by the JWT middleware for tokens of a revoked session
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// sessionClaims It is implemented by the claims carrying a session id. See
+// Claims.SessionID
+type sessionClaims interface {
+	GetSessionID() string
+}
+
+// RevokeSession It adds the session to the revocation list checked by the
+// JWT middleware when JWTConfig.CheckRevocation is set. The ttl has to be at
+// least the lifetime of the access tokens issued for the session
+func RevokeSession(c context.Context, r *cache.Redis, sessionId string, ttl time.Duration) error {
+	if r == nil {
+		return errors.New("Redis is required to revoke sessions")
+	}
+	return r.Set(c, fmt.Sprintf(revokedSessionKey, sessionId), 1, ttl)
+}
+
+// isRevoked It tells if the session of the token has been revoked. Tokens
+// without a session id cannot be revoked
+func isRevoked(c context.Context, r *cache.Redis, claims jwt.Claims) (bool, error) {
+	sc, ok := claims.(sessionClaims)
+	if !ok || sc.GetSessionID() == "" {
+		return false, nil
+	}
+	return r.Exists(c, fmt.Sprintf(revokedSessionKey, sc.GetSessionID()))
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"context"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+const instrumentationName = "github.com/suhailgupta03/thunderbyte/common"
+
+// The global tracer and meter delegate to whatever providers are installed
+// later on, so these stay no-ops unless OpenTelemetry is enabled in core
+var (
+	tracer             = otel.Tracer(instrumentationName)
+	meter              = otel.Meter(instrumentationName)
+	requestDuration, _ = meter.Float64Histogram("http.server.request.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of HTTP requests handled by the controllers"))
+)
+
+// startRequestSpan It starts a server span for the request, continuing the
+// trace sent by the caller through the W3C traceparent header. The request
+// on c is replaced with one carrying the span
+func startRequestSpan(c echo.Context, modulePath RoutePath) trace.Span {
+	req := c.Request()
+	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx, span := tracer.Start(ctx, req.Method+" "+c.Path(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.HTTPRoute(c.Path()),
+			attribute.String("thunderbyte.module", string(modulePath)),
+		))
+	c.SetRequest(req.WithContext(ctx))
+	return span
+}
+
+// endRequestSpan It records the outcome of the request on the span
+// and the request duration histogram
+func endRequestSpan(c echo.Context, span trace.Span, modulePath RoutePath, statusCode int, errMsg string, start time.Time) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(statusCode))
+	if statusCode >= 500 {
+		span.SetStatus(codes.Error, errMsg)
+	}
+	span.End()
+
+	requestDuration.Record(context.Background(), time.Since(start).Seconds(), metric.WithAttributes(
+		semconv.HTTPRequestMethodKey.String(c.Request().Method),
+		semconv.HTTPRoute(c.Path()),
+		semconv.HTTPResponseStatusCode(statusCode),
+		attribute.String("thunderbyte.module", string(modulePath)),
+	))
+}
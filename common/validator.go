@@ -3,6 +3,8 @@ package common
 import (
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"reflect"
+	"strings"
 )
 
 type RequestValidator struct {
@@ -13,7 +15,23 @@ func (cv *RequestValidator) Validate(i interface{}) error {
 	return cv.validator.Struct(i)
 }
 
-// NewRequestValidator creates a new RequestValidator
+// NewRequestValidator creates a new RequestValidator. Validation errors
+// refer to fields by the name they are bound from
 func NewRequestValidator() echo.Validator {
-	return &RequestValidator{validator: validator.New()}
+	v := validator.New()
+	v.RegisterTagNameFunc(RequestFieldName)
+	return &RequestValidator{validator: v}
+}
+
+// RequestFieldName It returns the name a struct field is bound from using
+// the json, form, query and param tags in that order, falling back to the
+// Go name. It is meant for validator.RegisterTagNameFunc
+func RequestFieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "query", "param"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
 }
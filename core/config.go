@@ -0,0 +1,299 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/knadh/koanf/v2"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"github.com/suhailgupta03/thunderbyte/otp/providers/smtp"
+	"github.com/suhailgupta03/thunderbyte/otp/store/redis"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Config It holds the settings read from the well-known koanf sections.
+// A nil section means that it was absent from the configuration
+type Config struct {
+	DB    *database.DBConfig
+	Redis *redis.Conf
+	SMTP  *smtp.Config
+	O11Y  *O11Y
+	HTTP  HTTPConfig
+}
+
+// HTTPConfig It holds the settings of the `http` section
+type HTTPConfig struct {
+	// Port It is listened on when 0 is passed to TBApp.Run or Listen.
+	// Defaults to 8080
+	Port            int
+	ShutdownTimeout time.Duration
+	// JWTSecret It can be used as ControllerConfig.JWTSecret by the modules
+	JWTSecret string
+}
+
+// configReader It reads typed values out of koanf and records every
+// missing or invalid key instead of stopping at the first one
+type configReader struct {
+	k    *koanf.Koanf
+	errs []error
+}
+
+func (r *configReader) fail(key string, format string, args ...any) {
+	r.errs = append(r.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+func (r *configReader) string(key string, required bool, def string) string {
+	if !r.k.Exists(key) {
+		if required {
+			r.fail(key, "is required")
+		}
+		return def
+	}
+	v := r.k.String(key)
+	if required && v == "" {
+		r.fail(key, "cannot be empty")
+	}
+	return v
+}
+
+func (r *configReader) int(key string, required bool, def int) int {
+	if !r.k.Exists(key) {
+		if required {
+			r.fail(key, "is required")
+		}
+		return def
+	}
+	v, err := strconv.Atoi(fmt.Sprint(r.k.Get(key)))
+	if err != nil {
+		r.fail(key, "must be an integer")
+		return def
+	}
+	return v
+}
+
+func (r *configReader) strings(key string) []string {
+	if !r.k.Exists(key) {
+		return nil
+	}
+	return r.k.Strings(key)
+}
+
+func (r *configReader) bool(key string) bool {
+	if !r.k.Exists(key) {
+		return false
+	}
+	v, err := strconv.ParseBool(fmt.Sprint(r.k.Get(key)))
+	if err != nil {
+		r.fail(key, "must be a boolean")
+	}
+	return v
+}
+
+func (r *configReader) duration(key string, def time.Duration) time.Duration {
+	if !r.k.Exists(key) {
+		return def
+	}
+	v, err := time.ParseDuration(fmt.Sprint(r.k.Get(key)))
+	if err != nil {
+		r.fail(key, "must be a duration such as 5s")
+		return def
+	}
+	return v
+}
+
+func (r *configReader) oneOf(key string, v string, allowed ...string) {
+	for _, a := range allowed {
+		if v == a {
+			return
+		}
+	}
+	r.fail(key, "must be one of %v", allowed)
+}
+
+// LoadConfig It reads the `db`, `redis`, `smtp`, `o11y` and `http` sections
+// from k. All missing and invalid keys are reported together in the
+// returned error
+func LoadConfig(k *koanf.Koanf) (*Config, error) {
+	r := &configReader{k: k}
+	cfg := &Config{
+		HTTP: HTTPConfig{
+			Port:            r.int("http.port", false, 8080),
+			ShutdownTimeout: r.duration("http.shutdown_timeout", defaultShutdownTimeout),
+			JWTSecret:       r.string("http.jwt_secret", false, ""),
+		},
+	}
+
+	if p := cfg.HTTP.Port; p < 0 || p > 65535 {
+		r.fail("http.port", "must be between 0 and 65535")
+	}
+
+	if k.Exists("db") {
+		cfg.DB = &database.DBConfig{
+			Type:     database.DBType(r.string("db.type", false, string(database.Postgres))),
+			Host:     r.string("db.host", true, ""),
+			Port:     r.int("db.port", false, 5432),
+			User:     r.string("db.user", true, ""),
+			Password: r.string("db.password", false, ""),
+			Database: r.string("db.database", true, ""),
+			SSLMode:  r.string("db.ssl_mode", false, "disable"),
+			Params:   r.string("db.params", false, ""),
+		}
+		r.oneOf("db.type", string(cfg.DB.Type), string(database.Postgres))
+		if k.Exists("db.query_file") {
+			queryFile := r.string("db.query_file", true, "")
+			cfg.DB.QueryFilePath = &queryFile
+		}
+		if k.Exists("db.schema_file") {
+			schemaFile := r.string("db.schema_file", true, "")
+			cfg.DB.SchemaFilePath = &schemaFile
+		}
+	}
+
+	if k.Exists("redis") {
+		mode := r.string("redis.mode", false, redis.ModeStandalone)
+		addrs := r.strings("redis.addrs")
+		cfg.Redis = &redis.Conf{
+			Mode:             mode,
+			Host:             r.string("redis.host", len(addrs) == 0, ""),
+			Port:             r.int("redis.port", false, 6379),
+			Addrs:            addrs,
+			Username:         r.string("redis.username", false, ""),
+			Password:         r.string("redis.password", false, ""),
+			DB:               r.int("redis.db", false, 0),
+			MaxActive:        r.int("redis.max_active", false, 0),
+			MaxIdle:          r.int("redis.max_idle", false, 0),
+			Timeout:          r.duration("redis.timeout", 5*time.Second),
+			MasterName:       r.string("redis.master_name", mode == redis.ModeSentinel, ""),
+			SentinelUsername: r.string("redis.sentinel_username", false, ""),
+			SentinelPassword: r.string("redis.sentinel_password", false, ""),
+			TLS: redis.TLSConf{
+				Enabled:            r.bool("redis.tls.enabled"),
+				ServerName:         r.string("redis.tls.server_name", false, ""),
+				CAFile:             r.string("redis.tls.ca_file", false, ""),
+				CertFile:           r.string("redis.tls.cert_file", false, ""),
+				KeyFile:            r.string("redis.tls.key_file", false, ""),
+				InsecureSkipVerify: r.bool("redis.tls.insecure_skip_verify"),
+			},
+			KeyPrefix:  r.string("redis.key_prefix", false, ""),
+			PublishKey: r.string("redis.publish_key", false, ""),
+		}
+		r.oneOf("redis.mode", mode, redis.ModeStandalone, redis.ModeSentinel, redis.ModeCluster)
+		if mode == redis.ModeCluster && cfg.Redis.DB != 0 {
+			r.fail("redis.db", "must be 0 in cluster mode")
+		}
+	}
+
+	if k.Exists("smtp") {
+		cfg.SMTP = &smtp.Config{
+			Host:          r.string("smtp.host", true, ""),
+			Port:          r.int("smtp.port", true, 0),
+			AuthProtocol:  r.string("smtp.auth_protocol", false, "none"),
+			Username:      r.string("smtp.username", false, ""),
+			Password:      r.string("smtp.password", false, ""),
+			FromEmail:     r.string("smtp.from_email", false, ""),
+			Timeout:       r.duration("smtp.timeout", 5*time.Second),
+			MaxConns:      r.int("smtp.max_conns", false, 10),
+			TLSType:       r.string("smtp.tls_type", false, "STARTTLS"),
+			TLSSkipVerify: r.bool("smtp.tls_skip_verify"),
+		}
+		r.oneOf("smtp.auth_protocol", cfg.SMTP.AuthProtocol, "none", "login", "cram", "plain")
+		r.oneOf("smtp.tls_type", cfg.SMTP.TLSType, "none", "STARTTLS", "TLS")
+	}
+
+	if k.Exists("o11y.newrelic") {
+		nr := &NewRelicConfig{
+			Enabled:    r.bool("o11y.newrelic.enabled"),
+			AppName:    r.string("o11y.newrelic.app_name", false, ""),
+			LicenseKey: r.string("o11y.newrelic.license_key", false, ""),
+			UserKey:    r.string("o11y.newrelic.user_key", false, ""),
+		}
+		if nr.Enabled {
+			r.string("o11y.newrelic.app_name", true, "")
+			r.string("o11y.newrelic.license_key", true, "")
+		}
+		cfg.O11Y = &O11Y{NewRelic: nr}
+	}
+
+	if k.Exists("o11y.opentelemetry") {
+		ot := &OpenTelemetryConfig{
+			Enabled:     r.bool("o11y.opentelemetry.enabled"),
+			ServiceName: r.string("o11y.opentelemetry.service_name", false, ""),
+			Exporter:    r.string("o11y.opentelemetry.exporter", false, OTelExporterOTLP),
+			Endpoint:    r.string("o11y.opentelemetry.endpoint", false, ""),
+			Insecure:    r.bool("o11y.opentelemetry.insecure"),
+		}
+		if k.Exists("o11y.opentelemetry.sample_ratio") {
+			ratio, err := strconv.ParseFloat(fmt.Sprint(k.Get("o11y.opentelemetry.sample_ratio")), 64)
+			if err != nil || ratio < 0 || ratio > 1 {
+				r.fail("o11y.opentelemetry.sample_ratio", "must be a number between 0 and 1")
+			}
+			ot.SampleRatio = ratio
+		}
+		r.oneOf("o11y.opentelemetry.exporter", ot.Exporter, OTelExporterOTLP, OTelExporterStdout)
+		if cfg.O11Y == nil {
+			cfg.O11Y = &O11Y{}
+		}
+		cfg.O11Y.OpenTelemetry = ot
+	}
+
+	if len(r.errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(r.errs...))
+	}
+	return cfg, nil
+}
+
+// CreateFromConfig It loads the configuration from k and creates a TBApp
+// out of it. Fields already set on fc take precedence over the ones read
+// from the configuration. fc itself is not modified. Use LoadConfig
+// beforehand if the controllers need values such as the JWT secret
+func CreateFromConfig(k *koanf.Koanf, fc *FactoryCreate) (*TBApp, error) {
+	cfg, err := LoadConfig(k)
+	if err != nil {
+		return nil, err
+	}
+	var f FactoryCreate
+	if fc != nil {
+		f = *fc
+	}
+
+	f.K = k
+	if f.DBConfig == nil {
+		f.DBConfig = cfg.DB
+	}
+	// Created here, so it has to be closed if a later step fails
+	var redisClient io.Closer
+	if f.Redis == nil && cfg.Redis != nil {
+		client, err := redis.NewClient(*cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		redisClient = client
+		f.Redis = cache.New(client)
+		if f.OTPStore == nil {
+			f.OTPStore = redis.NewWithClient(client, *cfg.Redis)
+		}
+	}
+	if f.SMTPPool == nil && cfg.SMTP != nil {
+		pool, err := smtp.NewPool(*cfg.SMTP)
+		if err != nil {
+			if redisClient != nil {
+				redisClient.Close()
+			}
+			return nil, fmt.Errorf("smtp: %w", err)
+		}
+		f.SMTPPool = pool
+		f.SMTPConfig = cfg.SMTP
+	}
+	if f.O11Y == nil {
+		f.O11Y = cfg.O11Y
+	}
+	if f.ShutdownTimeout == 0 {
+		f.ShutdownTimeout = cfg.HTTP.ShutdownTimeout
+	}
+
+	app := (&TBFactory{}).Create(&f)
+	app.Config = cfg
+	return app, nil
+}
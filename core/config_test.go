@@ -0,0 +1,160 @@
+package core
+
+import (
+	"github.com/knadh/koanf/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKoanf(t *testing.T, values map[string]interface{}) *koanf.Koanf {
+	t.Helper()
+	k := koanf.New(".")
+	for key, v := range values {
+		if err := k.Set(key, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return k
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		wantErrs []string
+		check    func(t *testing.T, cfg *Config)
+	}{
+		{
+			name:   "defaults",
+			values: map[string]interface{}{},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Port != 8080 || cfg.HTTP.ShutdownTimeout != defaultShutdownTimeout {
+					t.Errorf("got http %+v", cfg.HTTP)
+				}
+				if cfg.DB != nil || cfg.Redis != nil || cfg.SMTP != nil || cfg.O11Y != nil {
+					t.Error("absent sections were set")
+				}
+			},
+		},
+		{
+			name: "valid sections",
+			values: map[string]interface{}{
+				"http.port":             "9000",
+				"http.shutdown_timeout": "3s",
+				"db.host":               "localhost",
+				"db.user":               "tb",
+				"db.database":           "tb",
+				"redis.addrs":           []string{"a:6379", "b:6379"},
+				"redis.mode":            "cluster",
+				"smtp.host":             "mail",
+				"smtp.port":             587,
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Port != 9000 || cfg.HTTP.ShutdownTimeout != 3*time.Second {
+					t.Errorf("got http %+v", cfg.HTTP)
+				}
+				if cfg.DB == nil || cfg.DB.Port != 5432 || cfg.DB.SSLMode != "disable" {
+					t.Errorf("got db %+v", cfg.DB)
+				}
+				if cfg.Redis == nil || len(cfg.Redis.Addrs) != 2 {
+					t.Errorf("got redis %+v", cfg.Redis)
+				}
+				if cfg.SMTP == nil || cfg.SMTP.Port != 587 || cfg.SMTP.TLSType != "STARTTLS" {
+					t.Errorf("got smtp %+v", cfg.SMTP)
+				}
+			},
+		},
+		{
+			name: "missing keys",
+			values: map[string]interface{}{
+				"db.port":   5432,
+				"smtp.host": "mail",
+			},
+			wantErrs: []string{"db.host: is required", "db.user: is required", "db.database: is required", "smtp.port: is required"},
+		},
+		{
+			name: "invalid keys",
+			values: map[string]interface{}{
+				"http.port":             "eighty",
+				"http.shutdown_timeout": "10",
+				"db.host":               "localhost",
+				"db.user":               "tb",
+				"db.database":           "tb",
+				"db.type":               "mysql",
+				"redis.host":            "localhost",
+				"redis.mode":            "cluster",
+				"redis.db":              2,
+				"redis.tls.enabled":     "maybe",
+			},
+			wantErrs: []string{
+				"http.port: must be an integer",
+				"http.shutdown_timeout: must be a duration",
+				"db.type: must be one of",
+				"redis.db: must be 0 in cluster mode",
+				"redis.tls.enabled: must be a boolean",
+			},
+		},
+		{
+			name: "conditional keys",
+			values: map[string]interface{}{
+				"http.port":                       70000,
+				"redis.mode":                      "sentinel",
+				"o11y.newrelic.enabled":           true,
+				"o11y.opentelemetry.exporter":     "zipkin",
+				"o11y.opentelemetry.sample_ratio": 2,
+			},
+			wantErrs: []string{
+				"http.port: must be between 0 and 65535",
+				"redis.host: is required",
+				"redis.master_name: is required",
+				"o11y.newrelic.app_name: is required",
+				"o11y.newrelic.license_key: is required",
+				"o11y.opentelemetry.exporter: must be one of",
+				"o11y.opentelemetry.sample_ratio: must be a number between 0 and 1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(newKoanf(t, tt.values))
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.check(t, cfg)
+				return
+			}
+			if err == nil {
+				t.Fatal("LoadConfig() succeeded")
+			}
+			// Every problem is reported in the same error
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error does not report %q:\n%v", want, err)
+				}
+			}
+			if got := strings.Count(err.Error(), "\n"); got != len(tt.wantErrs) {
+				t.Errorf("got %d problems, want %d:\n%v", got, len(tt.wantErrs), err)
+			}
+		})
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		port int
+		want string
+	}{
+		{"explicit port", &Config{HTTP: HTTPConfig{Port: 9000}}, 8000, ":8000"},
+		{"configured port", &Config{HTTP: HTTPConfig{Port: 9000}}, 0, ":9000"},
+		{"no config", nil, 0, ":0"},
+	}
+	for _, tt := range tests {
+		if got := (&TBApp{Config: tt.cfg}).address(tt.port); got != tt.want {
+			t.Errorf("%s: address(%d) = %s, want %s", tt.name, tt.port, got, tt.want)
+		}
+	}
+}
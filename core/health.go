@@ -0,0 +1,202 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/suhailgupta03/thunderbyte/otp/providers/smtp"
+	"net"
+	"net/http"
+	netsmtp "net/smtp"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	livenessPath        = "/healthz"
+	readinessPath       = "/readyz"
+	defaultCheckTimeout = 2 * time.Second
+	healthStatusOK      = "ok"
+	healthStatusFailed  = "failed"
+)
+
+// HealthCheck It is a single readiness check run by /readyz
+type HealthCheck struct {
+	Name string
+	// Timeout It overrides HealthConfig.Timeout for this check
+	Timeout time.Duration
+	Check   func(ctx context.Context) error
+}
+
+// HealthChecker It can be implemented by providers passed through
+// FactoryCreate.Providers to take part in the readiness checks
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// HealthConfig It enables the /healthz and /readyz endpoints. Postgres,
+// Redis and SMTP checks are added automatically when they are configured
+type HealthConfig struct {
+	// Timeout It is the default per-check timeout. Defaults to 2 seconds
+	Timeout time.Duration
+	// Checks It holds additional checks to be run by /readyz. Their names
+	// must be unique, including among the automatic checks
+	Checks []HealthCheck
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"durationMs"`
+}
+
+type healthResp struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// SMTPHealthCheck It returns a check that opens an SMTP session with the
+// server of the config, negotiating TLS the way the pool of smtp.NewPool
+// does, and ends it with QUIT
+func SMTPHealthCheck(cfg smtp.Config) HealthCheck {
+	return HealthCheck{
+		Name: "smtp",
+		Check: func(ctx context.Context) error {
+			tlsConfig := &tls.Config{ServerName: cfg.Host, InsecureSkipVerify: cfg.TLSSkipVerify}
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)))
+			if err != nil {
+				return err
+			}
+			if deadline, ok := ctx.Deadline(); ok {
+				conn.SetDeadline(deadline)
+			}
+			if cfg.TLSType == "TLS" {
+				conn = tls.Client(conn, tlsConfig)
+			}
+			c, err := netsmtp.NewClient(conn, cfg.Host)
+			if err != nil {
+				conn.Close()
+				return err
+			}
+			defer c.Close()
+			if cfg.TLSType != "none" && cfg.TLSType != "TLS" {
+				if err := c.StartTLS(tlsConfig); err != nil {
+					return err
+				}
+			}
+			if err := c.Noop(); err != nil {
+				return err
+			}
+			return c.Quit()
+		},
+	}
+}
+
+// validateHealthChecks It rejects the checks without a name or with a
+// name already taken, as their results are keyed by name
+func validateHealthChecks(checks []HealthCheck) error {
+	seen := make(map[string]bool, len(checks))
+	for _, hc := range checks {
+		if hc.Name == "" {
+			return fmt.Errorf("health check without a name")
+		}
+		if seen[hc.Name] {
+			return fmt.Errorf("duplicate health check name %q", hc.Name)
+		}
+		seen[hc.Name] = true
+	}
+	return nil
+}
+
+// providerHealthChecks It returns a check for every provider
+// implementing HealthChecker
+func providerHealthChecks(providers []interface{}) []HealthCheck {
+	var checks []HealthCheck
+	for _, p := range providers {
+		if hc, ok := p.(HealthChecker); ok {
+			checks = append(checks, HealthCheck{
+				Name:  reflect.Indirect(reflect.ValueOf(p)).Type().Name(),
+				Check: hc.HealthCheck,
+			})
+		}
+	}
+	return checks
+}
+
+// runCheck It runs a single check with its timeout
+func runCheck(ctx context.Context, hc HealthCheck, timeout time.Duration) checkResult {
+	if hc.Timeout > 0 {
+		timeout = hc.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("check panicked: %v", r)
+			}
+		}()
+		errCh <- hc.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := checkResult{Status: healthStatusOK, Duration: time.Since(start).Milliseconds()}
+	if err != nil {
+		res.Status = healthStatusFailed
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// registerHealthRoutes It registers the liveness and readiness endpoints
+func registerHealthRoutes(srv *echo.Echo, cfg *HealthConfig, checks []HealthCheck) {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultCheckTimeout
+	}
+
+	srv.GET(livenessPath, func(c echo.Context) error {
+		return c.JSON(http.StatusOK, healthResp{Status: healthStatusOK})
+	})
+
+	srv.GET(readinessPath, func(c echo.Context) error {
+		var (
+			mu   sync.Mutex
+			wg   sync.WaitGroup
+			resp = healthResp{Status: healthStatusOK, Checks: make(map[string]checkResult, len(checks))}
+		)
+		for _, hc := range checks {
+			wg.Add(1)
+			go func(hc HealthCheck) {
+				defer wg.Done()
+				res := runCheck(c.Request().Context(), hc, timeout)
+				mu.Lock()
+				defer mu.Unlock()
+				resp.Checks[hc.Name] = res
+				if res.Status != healthStatusOK {
+					resp.Status = healthStatusFailed
+				}
+			}(hc)
+		}
+		wg.Wait()
+
+		code := http.StatusOK
+		if resp.Status != healthStatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		return c.JSON(code, resp)
+	})
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"github.com/suhailgupta03/thunderbyte/otp"
+)
+
+const defaultMetricsPath = "/metrics"
+
+// MetricsConfig It enables the Prometheus metrics endpoint
+type MetricsConfig struct {
+	// Path It is where the metrics are served. Defaults to /metrics
+	Path string
+	// Registry If present the metrics are registered on it instead of
+	// a new registry owned by the app
+	Registry *prometheus.Registry
+}
+
+// redisPoolCollector It exposes the connection pool stats of the Redis service
+type redisPoolCollector struct {
+	r        *cache.Redis
+	hits     *prometheus.Desc
+	misses   *prometheus.Desc
+	timeouts *prometheus.Desc
+	total    *prometheus.Desc
+	idle     *prometheus.Desc
+	stale    *prometheus.Desc
+}
+
+func newRedisPoolCollector(r *cache.Redis) *redisPoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("thunderbyte", "redis_pool", name), help, nil, nil)
+	}
+	return &redisPoolCollector{
+		r:        r,
+		hits:     desc("hits_total", "Number of times a free connection was found in the pool."),
+		misses:   desc("misses_total", "Number of times a free connection was not found in the pool."),
+		timeouts: desc("timeouts_total", "Number of times a wait timeout occurred."),
+		total:    desc("connections", "Number of total connections in the pool."),
+		idle:     desc("idle_connections", "Number of idle connections in the pool."),
+		stale:    desc("stale_connections_total", "Number of stale connections removed from the pool."),
+	}
+}
+
+func (rc *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rc.hits
+	ch <- rc.misses
+	ch <- rc.timeouts
+	ch <- rc.total
+	ch <- rc.idle
+	ch <- rc.stale
+}
+
+func (rc *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := rc.r.Client().PoolStats()
+	ch <- prometheus.MustNewConstMetric(rc.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(rc.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(rc.timeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(rc.total, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(rc.idle, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(rc.stale, prometheus.CounterValue, float64(stats.StaleConns))
+}
+
+// initMetrics It creates the registry with the request, database, Redis and
+// OTP collectors and serves it on the configured path
+func initMetrics(srv *echo.Echo, cfg *MetricsConfig, db *sqlx.DB, dbName string, r *cache.Redis) *common.Metrics {
+	reg := cfg.Registry
+	if reg == nil {
+		reg = prometheus.NewRegistry()
+		reg.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+
+	// The registry may be shared by several apps, so everything is
+	// registered through common.RegisterShared
+	if db != nil {
+		common.RegisterShared(reg, collectors.NewDBStatsCollector(db.DB, dbName))
+	}
+	if r != nil {
+		common.RegisterShared(reg, newRedisPoolCollector(r))
+	}
+	for _, c := range otp.Collectors() {
+		common.RegisterShared(reg, c)
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = defaultMetricsPath
+	}
+	srv.GET(path, echo.WrapHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})))
+	metrics := common.NewMetrics(reg)
+	srv.Use(metrics.Middleware())
+	return metrics
+}
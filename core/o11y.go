@@ -6,7 +6,8 @@ import (
 )
 
 type O11Y struct {
-	NewRelic *NewRelicConfig
+	NewRelic      *NewRelicConfig
+	OpenTelemetry *OpenTelemetryConfig
 }
 
 type NewRelicConfig struct {
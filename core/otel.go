@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"github.com/suhailgupta03/thunderbyte/otp/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"io"
+	"os"
+)
+
+const (
+	OTelExporterOTLP   = "otlp"
+	OTelExporterStdout = "stdout"
+)
+
+type OpenTelemetryConfig struct {
+	Enabled     bool
+	ServiceName string
+	// Exporter It is either OTelExporterOTLP (default) or OTelExporterStdout
+	Exporter string
+	// Endpoint It is the host:port of the OTLP/HTTP collector. When empty the
+	// standard OTEL_EXPORTER_OTLP_ENDPOINT environment variable is used
+	Endpoint string
+	Insecure bool
+	// SampleRatio It is the fraction of new traces to be sampled. Defaults to 1
+	SampleRatio float64
+	// Writer It is where the stdout exporter writes to. Defaults to os.Stdout
+	Writer io.Writer
+}
+
+// initOpenTelemetry It installs the global tracer and meter providers along
+// with the W3C trace context propagator. The returned function flushes and
+// stops both providers
+func initOpenTelemetry(cfg *OpenTelemetryConfig) (func(context.Context) error, error) {
+	ctx := context.Background()
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(cfg.ServiceName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		spanExporter   sdktrace.SpanExporter
+		metricExporter sdkmetric.Exporter
+	)
+	switch cfg.Exporter {
+	case OTelExporterStdout:
+		w := cfg.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		if spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(w)); err != nil {
+			return nil, err
+		}
+		if metricExporter, err = stdoutmetric.New(stdoutmetric.WithWriter(w)); err != nil {
+			return nil, err
+		}
+	case OTelExporterOTLP, "":
+		traceOpts := []otlptracehttp.Option{}
+		metricOpts := []otlpmetrichttp.Option{}
+		if cfg.Endpoint != "" {
+			traceOpts = append(traceOpts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+			metricOpts = append(metricOpts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			traceOpts = append(traceOpts, otlptracehttp.WithInsecure())
+			metricOpts = append(metricOpts, otlpmetrichttp.WithInsecure())
+		}
+		if spanExporter, err = otlptracehttp.New(ctx, traceOpts...); err != nil {
+			return nil, err
+		}
+		if metricExporter, err = otlpmetrichttp.New(ctx, metricOpts...); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown OpenTelemetry exporter '%s'", cfg.Exporter)
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return func(ctx context.Context) error {
+		return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+	}, nil
+}
+
+// redisClientStore It is implemented by the OTP stores backed by go-redis
+type redisClientStore interface {
+	Client() goredis.UniversalClient
+}
+
+// instrumentRedis It adds tracing and metrics hooks to the client of the
+// Redis service and to the one of the OTP store when it has its own
+func instrumentRedis(r *cache.Redis, s store.Store) error {
+	var clients []goredis.UniversalClient
+	if r != nil {
+		clients = append(clients, r.Client())
+	}
+	if rs, ok := s.(redisClientStore); ok {
+		if r == nil || rs.Client() != r.Client() {
+			clients = append(clients, rs.Client())
+		}
+	}
+	var errs []error
+	for _, c := range clients {
+		errs = append(errs, redisotel.InstrumentTracing(c), redisotel.InstrumentMetrics(c))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
+	"github.com/zerodha/logf"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestSpanHasRedisChild(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+	otel.SetTracerProvider(tp)
+
+	mr := miniredis.RunT(t)
+	r := cache.New(goredis.NewClient(&goredis.Options{Addr: mr.Addr()}))
+	t.Cleanup(func() { _ = r.Close() })
+	if err := instrumentRedis(r, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := echo.New()
+	logger := logf.New(logf.Opts{})
+	common.InitModule([]*common.Module{{
+		ControllerConfig: &common.ControllerConfig{
+			ModulePath: "/cache",
+			Controllers: common.Controllers{
+				"/ping": {common.GET: {Handler: func(ctx common.AppContext, _ *common.InjectedServicesMap) (interface{}, *common.HTTPError) {
+					if err := ctx.Redis.Ping(ctx.Context()); err != nil {
+						return nil, &common.HTTPError{Code: http.StatusInternalServerError, Message: err.Error()}
+					}
+					return "pong", nil
+				}}},
+			},
+		},
+	}}, &common.InitModuleParams{Srv: srv, Redis: r, Logger: &logger}, nil)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/ping", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+
+	var server, child sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		switch {
+		case s.SpanKind() == trace.SpanKindServer:
+			server = s
+		case s.SpanKind() == trace.SpanKindClient:
+			child = s
+		}
+	}
+	if server == nil {
+		t.Fatal("no server span was recorded")
+	}
+	if got := server.Name(); got != "GET /cache/ping" {
+		t.Errorf("server span name = %q", got)
+	}
+	if child == nil {
+		t.Fatal("no span was recorded for the Redis call")
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("Redis span %q is not a child of the server span", child.Name())
+	}
+}
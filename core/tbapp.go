@@ -1,35 +1,68 @@
 package core
 
 import (
-	"github.com/go-playground/validator/v10"
+	"context"
+	"errors"
 	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
+	"github.com/suhailgupta03/thunderbyte/common"
 	"github.com/suhailgupta03/thunderbyte/database"
 	"github.com/zerodha/logf"
+	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+const defaultShutdownTimeout = 10 * time.Second
+
 type TBAppInterface interface {
 	Listen(port int)
+	Run(ctx context.Context, port int) error
+	Shutdown(ctx context.Context) error
 }
 
 type TBApp struct {
-	Logger         *logf.Logger
+	Logger *logf.Logger
+	// Srv It is the HTTP server owned by this app
+	Srv *echo.Echo
+	// Modules It holds every module registered on Srv
+	Modules        []*common.Module
 	DB             *sqlx.DB
 	DefaultQueries database.ThunderbyteQueries
 	DBConfig       *database.DBConfig
+	// Config It is set when the app was created through CreateFromConfig
+	Config *Config
+	// ShutdownTimeout It is the deadline used by Run to drain in-flight
+	// requests once a shutdown signal is received
+	ShutdownTimeout time.Duration
+	closers         []closer
+	shutdownOnce    sync.Once
+	shutdownErr     error
+}
+
+// closer It is a resource owned by the app that has to be
+// released when the app shuts down
+type closer struct {
+	name  string
+	close func() error
 }
 
-// Listen It starts the server and listens on the specified address
+// Listen It starts the server and listens on the specified port. A port of
+// 0 uses http.port when the app was created through CreateFromConfig
 func (tba *TBApp) Listen(port int) *echo.Echo {
+	srv := tba.Srv
 	srv.HideBanner = true
 	// Initialize the request validator
-	srv.Validator = &RequestValidator{validator: validator.New()}
+	srv.Validator = newRequestValidator()
 
 	// Start the server.
+	address := tba.address(port)
 	go func() {
-		address := ":" + strconv.Itoa(port)
 		if err := srv.Start(address); err != nil {
 			if strings.Contains(err.Error(), "Server closed") {
 				tba.Logger.Info("HTTP server shut down")
@@ -42,3 +75,78 @@ func (tba *TBApp) Listen(port int) *echo.Echo {
 	}()
 	return srv
 }
+
+// Run It starts the server on the specified port and blocks until ctx is
+// cancelled or the process receives SIGINT/SIGTERM. The app is then shut
+// down, giving in-flight requests ShutdownTimeout to complete. A port of 0
+// uses http.port when the app was created through CreateFromConfig
+func (tba *TBApp) Run(ctx context.Context, port int) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := tba.Srv
+	srv.HideBanner = true
+	srv.Validator = newRequestValidator()
+
+	address := tba.address(port)
+	startErr := make(chan error, 1)
+	go func() {
+		tba.Logger.Info("Starting HTTP server", "port", address)
+		if err := srv.Start(address); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			startErr <- err
+		}
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		tba.Logger.Info("Shutdown signal received")
+	case runErr = <-startErr:
+		tba.Logger.Error("Error starting HTTP server", "error", runErr)
+	}
+
+	timeout := tba.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return errors.Join(runErr, tba.Shutdown(shutdownCtx))
+}
+
+// address It returns the address to listen on for the port passed to Listen
+// or Run
+func (tba *TBApp) address(port int) string {
+	if port == 0 && tba.Config != nil {
+		port = tba.Config.HTTP.Port
+	}
+	return ":" + strconv.Itoa(port)
+}
+
+// Shutdown It stops accepting new connections, waits for in-flight requests
+// to finish until ctx expires and then closes every resource owned by the app
+// in the reverse order of initialization. Calling it more than once is a no-op
+func (tba *TBApp) Shutdown(ctx context.Context) error {
+	tba.shutdownOnce.Do(func() {
+		var errs []error
+		if err := tba.Srv.Shutdown(ctx); err != nil {
+			tba.Logger.Error("Failed to drain HTTP server", "error", err)
+			errs = append(errs, err)
+		} else {
+			tba.Logger.Info("HTTP server shut down")
+		}
+
+		for i := len(tba.closers) - 1; i >= 0; i-- {
+			c := tba.closers[i]
+			if err := c.close(); err != nil {
+				tba.Logger.Error("Failed to close resource", "resource", c.name, "error", err)
+				errs = append(errs, err)
+				continue
+			}
+			tba.Logger.Info("Closed resource", "resource", c.name)
+		}
+		tba.shutdownErr = errors.Join(errs...)
+	})
+	return tba.shutdownErr
+}
@@ -1,12 +1,19 @@
 package core
 
 import (
+	"context"
+	"github.com/jmoiron/sqlx"
 	"github.com/knadh/koanf/v2"
 	"github.com/labstack/echo/v4"
 	"github.com/newrelic/go-agent/v3/newrelic"
 	"github.com/suhailgupta03/smtppool"
 	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/suhailgupta03/thunderbyte/common/cache"
 	"github.com/suhailgupta03/thunderbyte/database"
+	"github.com/suhailgupta03/thunderbyte/otp"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/providers/smtp"
+	"github.com/suhailgupta03/thunderbyte/otp/store"
 	"github.com/suhailgupta03/thunderbyte/otp/store/redis"
 	"github.com/zerodha/logf"
 	"time"
@@ -19,20 +26,48 @@ type FactoryCreate struct {
 	DBConfig         *database.DBConfig
 	K                *koanf.Koanf
 	SMTPPool         *smtppool.Pool
-	Redis            *redis.Redis
 	ControllerConfig []*common.ControllerConfig
 	Providers        []interface{}
 	Imports          []*common.Module
 	O11Y             *O11Y
+	// SMTPConfig It is the config SMTPPool was created with. When present
+	// /readyz checks that its server accepts SMTP sessions
+	SMTPConfig *smtp.Config
+	// Redis It is the general purpose Redis service exposed to the handlers
+	// as AppContext.Redis. It is closed on shutdown
+	Redis *cache.Redis
+	// OTPStore It is where the OTP codes are stored. Defaults to a Redis
+	// store sharing the client of Redis. Its Stop method, if any, is
+	// called on shutdown
+	OTPStore store.Store
+	// Metrics If present the Prometheus metrics endpoint is registered on the server
+	Metrics *MetricsConfig
+	// Health If present /healthz and /readyz are registered on the server
+	Health *HealthConfig
+	// OTPProviders They are the OTP providers enabled at startup, keyed by
+	// the ID they are registered under, e.g. "smtp". They are available to
+	// the handlers through the otp.Service set on AppContext.OTP. Codes are
+	// stored in OTPStore
+	OTPProviders map[string]models.ProviderConfig
+	// OTPRegistry If present the OTPProviders are initialized on it. Custom
+	// providers have to be registered on it before Create. Defaults to
+	// otp.NewRegistry()
+	OTPRegistry *otp.Registry
+	// Logger If present it is used instead of the default logger
+	Logger *logf.Logger
+	// ShutdownTimeout It is the time given to in-flight requests to drain
+	// once a shutdown is triggered. Defaults to 10 seconds
+	ShutdownTimeout time.Duration
 }
 
 type TBFactoryInterface interface {
 	Create(fc *FactoryCreate) *TBApp
 }
 
-var (
-	srv    = echo.New()
-	logger = logf.New(logf.Opts{
+// newLogger It returns the default logger used when FactoryCreate
+// does not carry one
+func newLogger() logf.Logger {
+	return logf.New(logf.Opts{
 		EnableColor:          true,
 		Level:                logf.DebugLevel,
 		CallerSkipFrameCount: 3,
@@ -40,16 +75,65 @@ var (
 		TimestampFormat:      time.RFC3339Nano,
 		DefaultFields:        []any{"scope", "example"},
 	})
-)
+}
 
-// Create It returns a pointer to a new TBApp
+// Create It returns a pointer to a new TBApp. Every call owns its own
+// HTTP server, logger and module registry, so multiple apps can run
+// side by side in the same process
 func (tbf *TBFactory) Create(fc *FactoryCreate) *TBApp {
+	srv := echo.New()
+	logger := newLogger()
+	if fc.Logger != nil {
+		logger = *fc.Logger
+	}
+
 	if len(fc.ControllerConfig) == 0 {
 		logger.Fatal("ControllerConfig is required")
 	}
 
+	// Resources are handed over to the app in the order they were
+	// initialized so that they can be closed in reverse on shutdown.
+	// Redis and the SMTP pool are created by the caller before Create
+	var closers []closer
+	if fc.Redis != nil {
+		closers = append(closers, closer{name: "redis", close: fc.Redis.Close})
+	}
+	if fc.SMTPPool != nil {
+		closers = append(closers, closer{name: "smtp pool", close: func() error {
+			fc.SMTPPool.Close()
+			return nil
+		}})
+	}
+
+	shutdownTimeout := fc.ShutdownTimeout
+	if shutdownTimeout == 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+
+	// OpenTelemetry has to be set up before the database and Redis
+	// so that their clients can be instrumented
+	if fc.O11Y != nil && fc.O11Y.OpenTelemetry != nil && fc.O11Y.OpenTelemetry.Enabled {
+		shutdownOTel, err := initOpenTelemetry(fc.O11Y.OpenTelemetry)
+		if err != nil {
+			logger.Fatal("Failed to initialize OpenTelemetry", "error", err)
+		}
+		closers = append(closers, closer{name: "opentelemetry", close: func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			return shutdownOTel(ctx)
+		}})
+		if err := instrumentRedis(fc.Redis, fc.OTPStore); err != nil {
+			logger.Error("Failed to instrument Redis", "error", err)
+		}
+		if fc.DBConfig != nil {
+			fc.DBConfig.Tracing = true
+		}
+		logger.Info("OpenTelemetry enabled", "exporter", fc.O11Y.OpenTelemetry.Exporter)
+	}
+
 	if fc.DBConfig != nil {
 		database.ForRoot(fc.DBConfig, &logger)
+		closers = append(closers, closer{name: "database", close: fc.DBConfig.GetDB().Close})
 	}
 
 	if fc.O11Y != nil {
@@ -63,8 +147,63 @@ func (tbf *TBFactory) Create(fc *FactoryCreate) *TBApp {
 				logger.Error("Failed to initialize NewRelic", "error", err)
 			}
 			srv.Use(newRelicMiddleware(app))
+			closers = append(closers, closer{name: "newrelic", close: func() error {
+				app.Shutdown(shutdownTimeout)
+				return nil
+			}})
 		}
 	}
+	// Stores running background work, e.g. the sweeper of the Postgres
+	// store, are stopped before the database is closed
+	if s, ok := fc.OTPStore.(interface{ Stop() error }); ok {
+		closers = append(closers, closer{name: "otp store", close: s.Stop})
+	}
+	otpStore := fc.OTPStore
+	if otpStore == nil && fc.Redis != nil {
+		otpStore = redis.NewWithClient(fc.Redis.Client(), redis.Conf{})
+	}
+	var otpService *otp.Service
+	if fc.OTPRegistry != nil || len(fc.OTPProviders) > 0 {
+		if otpStore == nil {
+			logger.Fatal("OTP providers require an OTP store or Redis")
+		}
+		s, err := otp.NewService(otp.ServiceConfig{
+			Registry:  fc.OTPRegistry,
+			Providers: fc.OTPProviders,
+			Store:     otpStore,
+			Logger:    &logger,
+		})
+		if err != nil {
+			logger.Fatal("Failed to initialize OTP providers", "error", err)
+		}
+		otpService = s
+		logger.Info("Enabled OTP providers", "providers", s.Registry().IDs())
+	}
+
+	var metrics *common.Metrics
+	if fc.Metrics != nil {
+		var (
+			db     *sqlx.DB
+			dbName string
+		)
+		if fc.DBConfig != nil {
+			db, dbName = fc.DBConfig.GetDB(), fc.DBConfig.Database
+		}
+		metrics = initMetrics(srv, fc.Metrics, db, dbName, fc.Redis)
+		logger.Info("Registered metrics endpoint")
+	}
+
+	moduleParams := &common.InitModuleParams{
+		Logger:   &logger,
+		Srv:      srv,
+		DBConfig: fc.DBConfig,
+		Redis:    fc.Redis,
+		SMTPPool: fc.SMTPPool,
+		OTP:      otpService,
+		K:        fc.K,
+		Metrics:  metrics,
+	}
+	var modules []*common.Module
 	for _, cc := range fc.ControllerConfig {
 		if cc.Controllers != nil {
 			module := common.Module{
@@ -73,32 +212,52 @@ func (tbf *TBFactory) Create(fc *FactoryCreate) *TBApp {
 				ControllerConfig: cc,
 				Providers:        fc.Providers,
 			}
-			common.InitModule([]*common.Module{&module}, &common.InitModuleParams{
-				Logger:   &logger,
-				Srv:      srv,
-				DBConfig: fc.DBConfig,
-				Redis:    fc.Redis,
-				SMTPPool: fc.SMTPPool,
-				K:        fc.K,
-			}, nil)
+			common.InitModule([]*common.Module{&module}, moduleParams, nil)
+			modules = append(modules, &module)
 		}
 	}
 
-	common.InitModule(fc.Imports, &common.InitModuleParams{
-		Logger:   &logger,
-		Srv:      srv,
-		DBConfig: fc.DBConfig,
-		Redis:    fc.Redis,
-		SMTPPool: fc.SMTPPool,
-		K:        fc.K,
-	}, nil)
+	common.InitModule(fc.Imports, moduleParams, nil)
+	modules = append(modules, fc.Imports...)
+
+	if fc.Health != nil {
+		var checks []HealthCheck
+		if fc.DBConfig != nil {
+			checks = append(checks, HealthCheck{Name: "postgres", Check: fc.DBConfig.GetDB().PingContext})
+		}
+		if fc.Redis != nil {
+			checks = append(checks, HealthCheck{Name: "redis", Check: func(ctx context.Context) error {
+				return fc.Redis.Ping(ctx)
+			}})
+		}
+		if fc.OTPStore != nil {
+			checks = append(checks, HealthCheck{Name: "otp", Check: fc.OTPStore.Ping})
+		}
+		if fc.SMTPPool != nil && fc.SMTPConfig != nil {
+			checks = append(checks, SMTPHealthCheck(*fc.SMTPConfig))
+		}
+		checks = append(checks, providerHealthChecks(fc.Providers)...)
+		checks = append(checks, fc.Health.Checks...)
+		if err := validateHealthChecks(checks); err != nil {
+			logger.Fatal("Invalid health checks", "error", err)
+		}
+		registerHealthRoutes(srv, fc.Health, checks)
+		logger.Info("Registered health endpoints", "liveness", livenessPath, "readiness", readinessPath)
+	}
 
 	srv.Validator = common.NewRequestValidator()
 
-	return &TBApp{
-		Logger:         &logger,
-		DB:             fc.DBConfig.GetDB(),
-		DefaultQueries: fc.DBConfig.GetDefaultQueries(),
-		DBConfig:       fc.DBConfig,
+	app := &TBApp{
+		Logger:          &logger,
+		Srv:             srv,
+		Modules:         modules,
+		DBConfig:        fc.DBConfig,
+		ShutdownTimeout: shutdownTimeout,
+		closers:         closers,
+	}
+	if fc.DBConfig != nil {
+		app.DB = fc.DBConfig.GetDB()
+		app.DefaultQueries = fc.DBConfig.GetDefaultQueries()
 	}
+	return app
 }
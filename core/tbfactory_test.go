@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/suhailgupta03/thunderbyte/common"
+	"github.com/zerodha/logf"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer It is a log destination safe for concurrent writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// reply It returns a handler answering with v and logging it
+func reply(v string) common.HTTPMethodConfig {
+	return common.HTTPMethodConfig{common.GET: {Handler: func(ctx common.AppContext, _ *common.InjectedServicesMap) (interface{}, *common.HTTPError) {
+		ctx.Logger.Info("handled", "reply", v)
+		return v, nil
+	}}}
+}
+
+func get(t *testing.T, app *TBApp, path string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	app.Srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var body struct {
+		Data string `json:"data"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec.Code, body.Data
+}
+
+func TestParallelApps(t *testing.T) {
+	// Both apps import the same module, itself importing a nested one, and
+	// share a metrics registry
+	shared := &common.Module{
+		ControllerConfig: &common.ControllerConfig{ModulePath: "/shared", Controllers: common.Controllers{"/ping": reply("shared")}},
+		Imports: []*common.Module{{
+			ControllerConfig: &common.ControllerConfig{ModulePath: "/nested", Controllers: common.Controllers{"/ping": reply("nested")}},
+		}},
+	}
+	registry := prometheus.NewRegistry()
+
+	names := []string{"a", "b"}
+	apps := make([]*TBApp, len(names))
+	logs := make([]*syncBuffer, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logs[i] = &syncBuffer{}
+			l := logf.New(logf.Opts{Writer: logs[i], DefaultFields: []any{"app", name}})
+			apps[i] = (&TBFactory{}).Create(&FactoryCreate{
+				Logger:  &l,
+				Metrics: &MetricsConfig{Registry: registry},
+				ControllerConfig: []*common.ControllerConfig{
+					// Same module path, one route in common and one of its own
+					{ModulePath: "/users", Controllers: common.Controllers{
+						"/me":                        reply("me " + name),
+						common.RoutePath("/" + name): reply("only " + name),
+					}},
+				},
+				Imports: []*common.Module{shared},
+			})
+		}()
+	}
+	wg.Wait()
+
+	for i, name := range names {
+		other := names[1-i]
+		app := apps[i]
+		tests := []struct {
+			path     string
+			wantCode int
+			wantData string
+		}{
+			{"/users/me", http.StatusOK, "me " + name},
+			{"/users/" + name, http.StatusOK, "only " + name},
+			{"/users/" + other, http.StatusNotFound, ""},
+			{"/shared/ping", http.StatusOK, "shared"},
+			{"/shared/nested/ping", http.StatusOK, "nested"},
+			{"/nested/ping", http.StatusNotFound, ""},
+		}
+		var served sync.WaitGroup
+		for _, tt := range tests {
+			served.Add(1)
+			go func() {
+				defer served.Done()
+				if code, data := get(t, app, tt.path); code != tt.wantCode || data != tt.wantData {
+					t.Errorf("app %s: GET %s = %d %q, want %d %q", name, tt.path, code, data, tt.wantCode, tt.wantData)
+				}
+			}()
+		}
+		served.Wait()
+
+		if len(app.Modules) != 2 {
+			t.Errorf("app %s has %d modules, want 2", name, len(app.Modules))
+		}
+		log := logs[i].String()
+		if !strings.Contains(log, "reply=\"me "+name+"\"") {
+			t.Errorf("app %s did not log its own requests:\n%s", name, log)
+		}
+		if strings.Contains(log, "app="+other) || strings.Contains(log, "me "+other) {
+			t.Errorf("app %s logged the requests of app %s:\n%s", name, other, log)
+		}
+	}
+
+	// The shared module is left as it was passed in
+	if shared.ControllerConfig.ModulePath != "/shared" || shared.Imports[0].ControllerConfig.ModulePath != "/nested" {
+		t.Error("registering a module modified its config")
+	}
+}
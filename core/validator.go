@@ -3,6 +3,7 @@ package core
 import (
 	"errors"
 	"github.com/go-playground/validator/v10"
+	"github.com/suhailgupta03/thunderbyte/common"
 	"reflect"
 )
 
@@ -10,6 +11,14 @@ type RequestValidator struct {
 	validator *validator.Validate
 }
 
+// newRequestValidator It returns a RequestValidator that reports
+// fields by the name they are bound from
+func newRequestValidator() *RequestValidator {
+	v := validator.New()
+	v.RegisterTagNameFunc(common.RequestFieldName)
+	return &RequestValidator{validator: v}
+}
+
 func (rv *RequestValidator) Validate(i interface{}) error {
 	if reflect.Struct != reflect.ValueOf(i).Kind() {
 		return errors.New("argument passed must be a struct")
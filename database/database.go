@@ -2,11 +2,13 @@ package database
 
 import (
 	"fmt"
+	"github.com/XSAM/otelsql"
 	"github.com/jmoiron/sqlx"
 	"github.com/knadh/goyesql/v2"
 	goyesqlx "github.com/knadh/goyesql/v2/sqlx"
 	"github.com/lib/pq"
 	"github.com/zerodha/logf"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"os"
 	"reflect"
 )
@@ -33,14 +35,17 @@ type DBConfig struct {
 	SQLFilePaths
 	// Queries must be a pointer kind ( a pointer to a struct )
 	Queries
-	Type            DBType
-	Host            string
-	Port            int
-	User            string
-	Password        string
-	Database        string
-	SSLMode         string
-	Params          string
+	Type     DBType
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+	SSLMode  string
+	Params   string
+	// Tracing If true every query is recorded as an OpenTelemetry span
+	// using the globally registered tracer provider
+	Tracing         bool
 	db              *sqlx.DB
 	l               *logf.Logger
 	defaultQuerySet ThunderbyteQueries
@@ -50,9 +55,7 @@ type DBConfig struct {
 // struct to be used throughout the application. Panics if it fails to achieve any of the condition
 func ForRoot(c *DBConfig, l *logf.Logger) {
 	l.Info("connecting to db", "host", c.Host, "port", c.Port, "database", c.Database)
-	db, err := sqlx.Connect(string(c.Type),
-		fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s %s", c.Host, c.Port, c.User, c.Password, c.Database, c.SSLMode, c.Params))
-
+	db, err := c.connect()
 	if err != nil {
 		panic(err)
 	}
@@ -65,7 +68,7 @@ func ForRoot(c *DBConfig, l *logf.Logger) {
 	var tbDQ ThunderbyteQueries
 	goyesqlx.ScanToStruct(&tbDQ, defaultQueryMap, c.db)
 	c.defaultQuerySet = tbDQ
-	
+
 	if c.SchemaFilePath != nil {
 		if _, err := c.db.Exec(string(readQueries(*c.SchemaFilePath))); err != nil {
 			c.l.Fatal("Failed while creating schema", "error", err)
@@ -84,6 +87,32 @@ func ForRoot(c *DBConfig, l *logf.Logger) {
 	}
 }
 
+// DSN returns the connection string of the database. It can be used to
+// open connections outside the pool, for instance a pq.Listener
+func (dbc *DBConfig) DSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s %s", dbc.Host, dbc.Port, dbc.User, dbc.Password, dbc.Database, dbc.SSLMode, dbc.Params)
+}
+
+// connect opens the connection pool and verifies it with a ping.
+// With tracing enabled the driver is wrapped by otelsql
+func (dbc *DBConfig) connect() (*sqlx.DB, error) {
+	dsn := dbc.DSN()
+	if !dbc.Tracing {
+		return sqlx.Connect(string(dbc.Type), dsn)
+	}
+
+	sqlDB, err := otelsql.Open(string(dbc.Type), dsn, otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
+	if err != nil {
+		return nil, err
+	}
+	db := sqlx.NewDb(sqlDB, string(dbc.Type))
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 func (dbc *DBConfig) GetDB() *sqlx.DB {
 	return dbc.db
 }
@@ -109,6 +138,10 @@ func (dbc *DBConfig) install() {
 			}
 		}
 	}
+
+	if _, err := tbd.Exec(getMigrationQueries()); err != nil {
+		panic("Error migrating the DB schema: " + err.Error())
+	}
 }
 
 // readQueries simply reads the file from the filepath
@@ -8,4 +8,16 @@ require (
 	github.com/lib/pq v1.10.9
 )
 
-require github.com/zerodha/logf v0.5.5 // indirect
+require (
+	github.com/XSAM/otelsql v0.37.0
+	github.com/zerodha/logf v0.5.5
+	go.opentelemetry.io/otel v1.35.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
+	go.opentelemetry.io/otel/metric v1.35.0 // indirect
+	go.opentelemetry.io/otel/trace v1.35.0 // indirect
+)
@@ -35,6 +35,102 @@ create table if not exists auth_passwords (
 );`
 }
 
+// getMigrationQueries returns the schema changes made after the initial
+// schema. They are run on every boot and must be idempotent
+func getMigrationQueries() string {
+	return `create table if not exists auth_roles (
+    id bigserial not null
+        constraint auth_roles_pk
+            primary key,
+    name text not null
+        constraint auth_roles_name_unique
+            unique
+);
+
+create table if not exists auth_permissions (
+    id bigserial not null
+        constraint auth_permissions_pk
+            primary key,
+    name text not null
+        constraint auth_permissions_name_unique
+            unique
+);
+
+create table if not exists auth_role_permissions (
+    role_id bigint not null
+        constraint auth_role_permissions_role_id_fk
+            references auth_roles on delete cascade,
+    permission_id bigint not null
+        constraint auth_role_permissions_permission_id_fk
+            references auth_permissions on delete cascade,
+    constraint auth_role_permissions_pk
+        primary key (role_id, permission_id)
+);
+
+create table if not exists auth_user_roles (
+    user_id bigint not null
+        constraint auth_user_roles_user_id_fk
+            references auth_users on delete cascade,
+    role_id bigint not null
+        constraint auth_user_roles_role_id_fk
+            references auth_roles on delete cascade,
+    constraint auth_user_roles_pk
+        primary key (user_id, role_id)
+);
+
+create table if not exists auth_sessions (
+    id uuid not null
+        constraint auth_sessions_pk
+            primary key,
+    family_id uuid not null,
+    user_id bigint not null
+        constraint auth_sessions_user_id_fk
+            references auth_users on delete cascade,
+    token_hash text not null
+        constraint auth_sessions_token_hash_unique
+            unique,
+    expires_at timestamptz not null,
+    created_at timestamptz not null default now(),
+    rotated_at timestamptz,
+    revoked_at timestamptz
+);
+
+create index if not exists auth_sessions_family_id_idx on auth_sessions (family_id);
+create index if not exists auth_sessions_user_id_idx on auth_sessions (user_id);
+
+alter table auth_users add column if not exists email text;
+alter table auth_users add column if not exists email_verified_at timestamptz;
+create unique index if not exists auth_users_email_unique on auth_users (lower(email));
+
+create table if not exists auth_totp (
+    user_id bigint not null
+        constraint auth_totp_pk
+            primary key
+        constraint auth_totp_user_id_fk
+            references auth_users on delete cascade,
+    secret text not null,
+    confirmed_at timestamptz,
+    created_at timestamptz not null default now()
+);
+
+create table if not exists auth_backup_codes (
+    id bigserial not null
+        constraint auth_backup_codes_pk
+            primary key,
+    user_id bigint not null
+        constraint auth_backup_codes_user_id_fk
+            references auth_users on delete cascade,
+    code_hash text not null,
+    created_at timestamptz not null default now(),
+    used_at timestamptz,
+    constraint auth_backup_codes_user_id_code_hash_unique
+        unique (user_id, code_hash)
+);
+
+-- The rows stored before hashing was introduced keep hashed = false
+alter table auth_passwords add column if not exists hashed boolean not null default false;`
+}
+
 func getDefaultRepoQueries() string {
 	return `
 	-- queries.sql
@@ -46,24 +142,142 @@ SELECT * FROM thunderbyte_settings;
 SELECT * FROM thunderbyte_settings where key=$1;
 
 -- name: verify-creds
+-- Returns the stored password of the user and whether it is a hash.
+-- It is verified in Go, passwords are never compared in SQL
 SELECT 
 	au.id as userid, 
-	au.username as username
+	au.username as username,
+	au.email as email,
+	au.email_verified_at as email_verified_at,
+	ap.password as password,
+	ap.hashed as hashed
 	FROM auth_users as au
-	left join auth_passwords as ap
+	join auth_passwords as ap
 	on au.id = ap.user_id
-	where au.username=$1 and ap.password=$2;
+	where au.username=$1;
 
 -- name: fetch-auth-profile-by-username
-SELECT id, username from auth_users where username = $1;
+SELECT id, username, email, email_verified_at from auth_users where username = $1;
 
 -- name: fetch-auth-profile-by-id
-SELECT id, username from auth_users where id = $1;
+SELECT id, username, email, email_verified_at from auth_users where id = $1;
+
+-- name: fetch-auth-profile-by-email
+SELECT id, username, email, email_verified_at from auth_users where lower(email) = lower($1);
 
 -- name: create-auth-profile
-INSERT INTO auth_users (username) VALUES ($1)
+INSERT INTO auth_users (username, email) VALUES ($1, NULLIF($2, ''))
 RETURNING id;
 
+-- name: mark-email-verified
+UPDATE auth_users SET email_verified_at = now()
+	where id = $1 and lower(email) = lower($2);
+
 -- name: create-password
-INSERT INTO auth_passwords (password,user_id) VALUES ($1,$2);`
+INSERT INTO auth_passwords (password,user_id,hashed) VALUES ($1,$2,true);
+
+-- name: update-password
+UPDATE auth_passwords SET password = $1, hashed = true where user_id = $2;
+
+-- name: fetch-legacy-passwords
+-- Rows stored before hashing was introduced
+SELECT user_id as userid, password FROM auth_passwords where not hashed;
+
+-- name: fetch-user-roles
+SELECT r.name FROM auth_roles as r
+	join auth_user_roles as ur
+	on ur.role_id = r.id
+	where ur.user_id = $1;
+
+-- name: fetch-user-permissions
+SELECT DISTINCT p.name FROM auth_permissions as p
+	join auth_role_permissions as rp
+	on rp.permission_id = p.id
+	join auth_user_roles as ur
+	on ur.role_id = rp.role_id
+	where ur.user_id = $1;
+
+-- name: assign-user-role
+INSERT INTO auth_user_roles (user_id, role_id)
+SELECT $1, id FROM auth_roles where name = $2
+ON CONFLICT DO NOTHING;
+
+-- name: remove-user-role
+DELETE FROM auth_user_roles as ur
+	USING auth_roles as r
+	where ur.role_id = r.id and ur.user_id = $1 and r.name = $2;
+
+-- name: fetch-role-users
+SELECT ur.user_id FROM auth_user_roles as ur
+	join auth_roles as r
+	on ur.role_id = r.id
+	where r.name = $1;
+
+-- name: grant-role-permission
+INSERT INTO auth_role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM auth_roles as r, auth_permissions as p
+	where r.name = $1 and p.name = $2
+ON CONFLICT DO NOTHING;
+
+-- name: revoke-role-permission
+DELETE FROM auth_role_permissions as rp
+	USING auth_roles as r, auth_permissions as p
+	where rp.role_id = r.id and rp.permission_id = p.id and r.name = $1 and p.name = $2;
+
+-- name: create-auth-session
+INSERT INTO auth_sessions (id, family_id, user_id, token_hash, expires_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: fetch-auth-session-for-update
+SELECT id, family_id, user_id, expires_at, rotated_at, revoked_at
+	FROM auth_sessions where token_hash = $1
+	FOR UPDATE;
+
+-- name: rotate-auth-session
+UPDATE auth_sessions SET rotated_at = now() where id = $1;
+
+-- name: revoke-auth-session-family
+UPDATE auth_sessions SET revoked_at = now()
+	where family_id = $1 and revoked_at is null;
+
+-- name: revoke-user-auth-sessions
+WITH revoked AS (
+	UPDATE auth_sessions SET revoked_at = now()
+	where user_id = $1 and revoked_at is null
+	RETURNING family_id
+)
+SELECT DISTINCT family_id FROM revoked;
+
+-- name: delete-expired-auth-sessions
+DELETE FROM auth_sessions where expires_at < now();
+
+-- name: upsert-totp-secret
+-- A confirmed secret is only replaced after it has been disabled
+INSERT INTO auth_totp (user_id, secret) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = now()
+	where auth_totp.confirmed_at is null;
+
+-- name: fetch-totp-secret
+SELECT user_id, secret, confirmed_at FROM auth_totp where user_id = $1;
+
+-- name: confirm-totp
+UPDATE auth_totp SET confirmed_at = now() where user_id = $1 and confirmed_at is null;
+
+-- name: delete-totp
+DELETE FROM auth_totp where user_id = $1;
+
+-- name: create-backup-code
+INSERT INTO auth_backup_codes (user_id, code_hash) VALUES ($1, $2);
+
+-- name: delete-backup-codes
+DELETE FROM auth_backup_codes where user_id = $1;
+
+-- name: consume-backup-code
+-- Concurrent requests with the same code cannot both succeed
+UPDATE auth_backup_codes SET used_at = now()
+	where user_id = $1 and code_hash = $2 and used_at is null
+	RETURNING id;
+
+-- name: count-backup-codes
+SELECT count(*) FROM auth_backup_codes where user_id = $1 and used_at is null;`
 }
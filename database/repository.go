@@ -1,11 +1,22 @@
 package database
 
-import "github.com/jmoiron/sqlx"
+import (
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
 
 const (
-	SETTINGS_REPO = "thunderbyte_settings"
-	AUTH_USER     = "auth_users"
-	AUTH_PASSWORD = "auth_passwords"
+	SETTINGS_REPO         = "thunderbyte_settings"
+	AUTH_USER             = "auth_users"
+	AUTH_PASSWORD         = "auth_passwords"
+	AUTH_ROLE             = "auth_roles"
+	AUTH_PERMISSION       = "auth_permissions"
+	AUTH_ROLE_PERMISSIONS = "auth_role_permissions"
+	AUTH_USER_ROLES       = "auth_user_roles"
+	AUTH_SESSIONS         = "auth_sessions"
+	AUTH_TOTP             = "auth_totp"
+	AUTH_BACKUP_CODES     = "auth_backup_codes"
 )
 
 type ThunderByteSetting struct {
@@ -17,13 +28,45 @@ type ThunderByteSetting struct {
 type ThunderByteSettings []ThunderByteSetting
 
 type VerifiedUser struct {
+	UserId          int64          `db:"userid"`
+	Username        string         `db:"username"`
+	Email           sql.NullString `db:"email"`
+	EmailVerifiedAt sql.NullTime   `db:"email_verified_at"`
+	// Password It is the stored password hash, or the plaintext password
+	// of a legacy row when Hashed is false
+	Password string `db:"password"`
+	Hashed   bool   `db:"hashed"`
+}
+
+type StoredPassword struct {
 	UserId   int64  `db:"userid"`
-	Username string `db:"username"`
+	Password string `db:"password"`
 }
 
 type AuthProfile struct {
-	UserId   int64  `db:"id"`
-	Username string `db:"username"`
+	UserId          int64          `db:"id"`
+	Username        string         `db:"username"`
+	Email           sql.NullString `db:"email"`
+	EmailVerifiedAt sql.NullTime   `db:"email_verified_at"`
+}
+
+// AuthSession It is a refresh token. Tokens rotated from the same login
+// share the family id, which is also the `sid` claim of the access tokens
+type AuthSession struct {
+	ID        string       `db:"id"`
+	FamilyID  string       `db:"family_id"`
+	UserId    int64        `db:"user_id"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	RotatedAt sql.NullTime `db:"rotated_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+}
+
+// TOTPSecret It is the encrypted TOTP secret of a user. It is not usable
+// for login until confirmed
+type TOTPSecret struct {
+	UserId      int64        `db:"user_id"`
+	Secret      string       `db:"secret"`
+	ConfirmedAt sql.NullTime `db:"confirmed_at"`
 }
 
 // Queries contains all prepared SQL queries.
@@ -35,6 +78,31 @@ type ThunderbyteQueries struct {
 	VerifyCredentials          *sqlx.Stmt `query:"verify-creds"`
 	FetchAuthProfileByUsername *sqlx.Stmt `query:"fetch-auth-profile-by-username"`
 	FetchAuthProfileById       *sqlx.Stmt `query:"fetch-auth-profile-by-id"`
+	FetchAuthProfileByEmail    *sqlx.Stmt `query:"fetch-auth-profile-by-email"`
+	MarkEmailVerified          *sqlx.Stmt `query:"mark-email-verified"`
 	CreateAuthProfile          *sqlx.Stmt `query:"create-auth-profile"`
 	CreatePassword             *sqlx.Stmt `query:"create-password"`
+	UpdatePassword             *sqlx.Stmt `query:"update-password"`
+	FetchLegacyPasswords       *sqlx.Stmt `query:"fetch-legacy-passwords"`
+	FetchUserRoles             *sqlx.Stmt `query:"fetch-user-roles"`
+	FetchUserPermissions       *sqlx.Stmt `query:"fetch-user-permissions"`
+	AssignUserRole             *sqlx.Stmt `query:"assign-user-role"`
+	RemoveUserRole             *sqlx.Stmt `query:"remove-user-role"`
+	FetchRoleUsers             *sqlx.Stmt `query:"fetch-role-users"`
+	GrantRolePermission        *sqlx.Stmt `query:"grant-role-permission"`
+	RevokeRolePermission       *sqlx.Stmt `query:"revoke-role-permission"`
+	CreateAuthSession          *sqlx.Stmt `query:"create-auth-session"`
+	FetchAuthSessionForUpdate  *sqlx.Stmt `query:"fetch-auth-session-for-update"`
+	RotateAuthSession          *sqlx.Stmt `query:"rotate-auth-session"`
+	RevokeAuthSessionFamily    *sqlx.Stmt `query:"revoke-auth-session-family"`
+	RevokeUserAuthSessions     *sqlx.Stmt `query:"revoke-user-auth-sessions"`
+	DeleteExpiredAuthSessions  *sqlx.Stmt `query:"delete-expired-auth-sessions"`
+	UpsertTOTPSecret           *sqlx.Stmt `query:"upsert-totp-secret"`
+	FetchTOTPSecret            *sqlx.Stmt `query:"fetch-totp-secret"`
+	ConfirmTOTP                *sqlx.Stmt `query:"confirm-totp"`
+	DeleteTOTP                 *sqlx.Stmt `query:"delete-totp"`
+	CreateBackupCode           *sqlx.Stmt `query:"create-backup-code"`
+	DeleteBackupCodes          *sqlx.Stmt `query:"delete-backup-codes"`
+	ConsumeBackupCode          *sqlx.Stmt `query:"consume-backup-code"`
+	CountBackupCodes           *sqlx.Stmt `query:"count-backup-codes"`
 }
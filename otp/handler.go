@@ -2,6 +2,7 @@ package otp
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
@@ -23,15 +24,19 @@ const (
 
 type SetOTPRequest struct {
 	// The URL where the server is running
-	RootURL            string
-	Namespace          string
-	CodeType           string
-	Provider           string
-	ID                 string
-	To                 string
-	OtpTTL             time.Duration
-	RawMaxAttempts     int
-	Extra              []byte
+	RootURL        string
+	Namespace      string
+	CodeType       string
+	Provider       string
+	ID             string
+	To             string
+	OtpTTL         time.Duration
+	RawMaxAttempts int
+	Extra          []byte
+	// Registry holds the provider selected by Provider. When nil an SMTP
+	// provider is built from SMTPConfig on every call. With a Registry,
+	// Subject and HTMLTemplateName must have been added with AddTemplate.
+	Registry           *Registry
 	SMTPConfig         *smtp.Config
 	HTMLTemplateName   string
 	Subject            string
@@ -77,17 +82,33 @@ type OTPResp struct {
 	Body    string `json:"body"`
 }
 
-// generateRandomString generates a cryptographically random,
-// alphanumeric string of length n.
-func generateRandomString(totalLen int, chars string) (string, error) {
-	bytes := make([]byte, totalLen)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
+// GenerateRandomString generates a cryptographically random string of
+// length totalLen made of the given chars.
+func GenerateRandomString(totalLen int, chars string) (string, error) {
+	if len(chars) == 0 || len(chars) > 256 {
+		return "", fmt.Errorf("invalid charset length %d", len(chars))
 	}
-	for k, v := range bytes {
-		bytes[k] = chars[v%byte(len(chars))]
+
+	// Random bytes at or above the largest multiple of len(chars) are
+	// discarded so that every char is equally likely.
+	limit := 256 - 256%len(chars)
+	out := make([]byte, 0, totalLen)
+	buf := make([]byte, totalLen)
+	for len(out) < totalLen {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, v := range buf {
+			if int(v) >= limit {
+				continue
+			}
+			out = append(out, chars[int(v)%len(chars)])
+			if len(out) == totalLen {
+				break
+			}
+		}
 	}
-	return string(bytes), nil
+	return string(out), nil
 }
 
 // isLocked tells if an OTP is locked after exceeding attempts.
@@ -139,25 +160,27 @@ func getSubjectAndBody(otp models.OTP, codeType string, p *provider, rootURL str
 }
 
 // push compiles a message template and pushes it to the provider.
-func push(otp models.OTP, codeType string, p *provider, rootURL string, otpTTL time.Duration) error {
+func push(ctx context.Context, otp models.OTP, codeType string, p *provider, rootURL string, otpTTL time.Duration) error {
 	var (
 		subj = &bytes.Buffer{}
 		out  = &bytes.Buffer{}
 	)
 
 	subj, out, _ = getSubjectAndBody(otp, codeType, p, rootURL, otpTTL)
-	return p.provider.Push(otp, subj.String(), out.Bytes())
+	return p.provider.Push(ctx, otp, subj.String(), out.Bytes())
 }
 
 // verifyOTP validates an OTP against user input.
-func verifyOTP(namespace, id, otp string, deleteOnVerify bool, s store.Store, lo *logf.Logger) (models.OTP, error) {
+func verifyOTP(ctx context.Context, namespace, id, otp string, deleteOnVerify bool, s store.Store, lo *logf.Logger) (models.OTP, error) {
 	// Check the OTP.
-	out, err := s.Check(namespace, id, true)
+	out, err := s.Check(ctx, namespace, id, true)
 	if err != nil {
 		if err != store.ErrNotExist {
 			lo.Error("error checking OTP", "error", err)
+			otpVerifyTotal.WithLabelValues(namespace, verifyResultError).Inc()
 			return out, err
 		}
+		otpVerifyTotal.WithLabelValues(namespace, verifyResultExpired).Inc()
 		return out, NewOTPError(OTPExpired, "OTP Expired. Please regenerate OTP")
 	}
 
@@ -168,11 +191,14 @@ func verifyOTP(namespace, id, otp string, deleteOnVerify bool, s store.Store, lo
 			out.TTL.Seconds())
 		otpError.ErrorCode = MaxAttemptsExceeded
 		otpError.RetryAfter = out.TTL
+		otpVerifyTotal.WithLabelValues(namespace, verifyResultLocked).Inc()
+		otpLockedTotal.WithLabelValues(namespace).Inc()
 	} else if out.OTP != otp {
 		// TODO: Pickup the noun "Passcode" from the config. It can be called OTP, Passcode, etc.
 		// TODO: Remove the hardcoded "Passcode" from the error message.
 		errMsg = "Incorrect Passcode"
 		otpError.ErrorCode = InvalidOTP
+		otpVerifyTotal.WithLabelValues(namespace, verifyResultInvalid).Inc()
 	}
 	otpError.Message = errMsg
 
@@ -183,70 +209,130 @@ func verifyOTP(namespace, id, otp string, deleteOnVerify bool, s store.Store, lo
 
 	// Delete the OTP?
 	if deleteOnVerify {
-		s.Delete(namespace, id)
+		s.Delete(ctx, namespace, id)
 	}
 
-	s.Close(namespace, id)
+	s.Close(ctx, namespace, id)
 	out.Closed = true
+	otpVerifyTotal.WithLabelValues(namespace, verifyResultSuccess).Inc()
 	return out, err
 }
 
+// SetRequest holds the parameters of a new OTP. See Service.Set.
+type SetRequest struct {
+	// The URL where the server is running
+	RootURL   string
+	Namespace string
+	CodeType  string
+	// Provider is the ID of a provider enabled on the registry.
+	Provider string
+	// ID identifies the OTP. A random one is generated when empty.
+	ID          string
+	To          string
+	TTL         time.Duration
+	MaxAttempts int
+	// Subject and Template override the templates of the provider. Template
+	// is the path of the body template file. The pair has to be added to
+	// the registry with Registry.AddTemplate first.
+	Subject            string
+	Template           string
+	ChannelDescription string
+	AddressDescription string
+	// Send pushes the OTP to the provider when To is set.
+	Send bool
+}
+
 // HandleSetOTP creates a new OTP while respecting maximum attempts
-// and TTL values.
-func HandleSetOTP(req SetOTPRequest) (*OTPResp, error) {
-	// TODO: Make the args of initProviders generic to reflect multiple providers
-	providers := initProviders(req.SMTPConfig, req.HTMLTemplateName, req.Subject, req.Lo)
-	p, ok := providers[req.Provider]
-	if !ok {
-		req.Lo.Error("Provider not supported. Failed to set OTP", "provider", req.Provider)
-		return nil, NewOTPError(ProviderNotSupported, fmt.Sprintf("%s provider not supported. Failed to set OTP", req.Provider))
+// and TTL values. ctx bounds the calls to the store and the provider.
+//
+// Deprecated: Use Service.Set. Without a Registry the SMTP provider and
+// its templates are rebuilt on every call.
+func HandleSetOTP(ctx context.Context, req SetOTPRequest) (*OTPResp, error) {
+	var p *provider
+	if req.Registry != nil {
+		rp, err := req.Registry.providerFor(req.Provider, req.Subject, req.HTMLTemplateName)
+		if err != nil {
+			req.Lo.Error("Failed to set OTP", "provider", req.Provider, "error", err)
+			return nil, err
+		}
+		p = rp
+	} else {
+		providers, err := initProviders(req.SMTPConfig, req.HTMLTemplateName, req.Subject, req.Lo)
+		if err != nil {
+			req.Lo.Error("error initializing providers", "error", err)
+			return nil, NewOTPError(OTPErrorUnknown, err.Error())
+		}
+		lp, ok := providers[req.Provider]
+		if !ok {
+			req.Lo.Error("Provider not supported. Failed to set OTP", "provider", req.Provider)
+			return nil, NewOTPError(ProviderNotSupported, fmt.Sprintf("%s provider not supported. Failed to set OTP", req.Provider))
+		}
+		p = lp
 	}
 
+	return setOTP(ctx, p, req.Store, req.Lo, SetRequest{
+		RootURL:            req.RootURL,
+		Namespace:          req.Namespace,
+		CodeType:           req.CodeType,
+		Provider:           req.Provider,
+		ID:                 req.ID,
+		To:                 req.To,
+		TTL:                time.Second * req.OtpTTL,
+		MaxAttempts:        req.RawMaxAttempts,
+		ChannelDescription: req.ChannelDescription,
+		AddressDescription: req.AddressDescription,
+		Send:               req.SendEmail,
+	})
+}
+
+// setOTP creates a new OTP with the provider and pushes it out.
+func setOTP(ctx context.Context, p *provider, s store.Store, lo *logf.Logger, req SetRequest) (*OTPResp, error) {
 	// Validate the 'to' address with the provider if one is given.
 	if req.To != "" {
 		if err := p.provider.ValidateAddress(req.To); err != nil {
-			req.Lo.Error("Invalid `to` address", "error", err)
+			lo.Error("Invalid `to` address", "error", err)
 			return nil, NewOTPError(OTPErrorUnknown, fmt.Sprintf("Invalid `to` address: %v", err))
 		}
 	}
 
-	if req.OtpTTL == time.Duration(0) {
-		req.Lo.Error("TTL value cannot be empty")
+	if req.TTL == time.Duration(0) {
+		lo.Error("TTL value cannot be empty")
 		return nil, NewOTPError(OTPErrorUnknown, fmt.Sprintf("TTL value cannot be empty"))
 	}
-	ttl := time.Second * req.OtpTTL
+	ttl := req.TTL
 
-	if req.RawMaxAttempts == 0 || req.RawMaxAttempts < 1 {
-		req.Lo.Error("Max attempts for OTP cannot be empty")
+	if req.MaxAttempts == 0 || req.MaxAttempts < 1 {
+		lo.Error("Max attempts for OTP cannot be empty")
 		return nil, NewOTPError(OTPErrorUnknown, fmt.Sprintf("Max attempts for OTP cannot be empty"))
 	}
 
-	maxAttempts := req.RawMaxAttempts
+	maxAttempts := req.MaxAttempts
 	id := req.ID
 	if id == "" {
-		if oid, err := generateRandomString(32, alphaNumChars); err != nil {
-			req.Lo.Error("error generating ID", "error", err)
+		if oid, err := GenerateRandomString(32, alphaNumChars); err != nil {
+			lo.Error("error generating ID", "error", err)
 			return nil, NewOTPError(OTPErrorUnknown, fmt.Sprintf("error generating ID %v", err))
 		} else {
 			id = oid
 		}
 	}
 
-	otpVal, err := generateRandomString(p.provider.MaxOTPLen(), numChars)
+	otpVal, err := GenerateRandomString(p.provider.MaxOTPLen(), numChars)
 	if err != nil {
 		return nil, NewOTPError(OTPErrorUnknown, fmt.Sprintf("error generating OTP %v", err))
 	}
 
 	// Check if the OTP attempts have exceeded the quota.
-	otp, err := req.Store.Check(req.Namespace, id, false)
+	otp, err := s.Check(ctx, req.Namespace, id, false)
 	if err != nil && err != store.ErrNotExist {
-		req.Lo.Error("error checking OTP status", "error", err)
+		lo.Error("error checking OTP status", "error", err)
 		return nil, NewOTPError(ConnectionToStoreFailed, fmt.Sprintf("error checking OTP status %v", err))
 	}
 
 	// There's an existing OTP that's locked.
 	if err != store.ErrNotExist && isLocked(otp) {
-		req.Lo.Error(fmt.Sprintf("OTP attempts exceeded. Retry after %0.f seconds.", otp.TTL.Seconds()))
+		otpLockedTotal.WithLabelValues(req.Namespace).Inc()
+		lo.Error(fmt.Sprintf("OTP attempts exceeded. Retry after %0.f seconds.", otp.TTL.Seconds()))
 		otpError := OTPError{
 			Message:    fmt.Sprintf("OTP attempts exceeded. Retry after %0.f seconds.", otp.TTL.Seconds()),
 			ErrorCode:  MaxAttemptsExceeded,
@@ -256,7 +342,7 @@ func HandleSetOTP(req SetOTPRequest) (*OTPResp, error) {
 	}
 
 	// Create the OTP.
-	newOTP, err := req.Store.Set(req.Namespace, id, models.OTP{
+	newOTP, err := s.Set(ctx, req.Namespace, id, models.OTP{
 		OTP:         otpVal,
 		To:          req.To,
 		ChannelDesc: req.ChannelDescription,
@@ -268,18 +354,19 @@ func HandleSetOTP(req SetOTPRequest) (*OTPResp, error) {
 	})
 
 	if err != nil {
-		req.Lo.Error("Error setting OTP", "error", err)
+		lo.Error("Error setting OTP", "error", err)
 		return nil, NewOTPError(SettingOTPFailed, fmt.Sprintf("Error setting OTP %v", err))
 	}
+	otpSetTotal.WithLabelValues(req.Namespace, req.Provider).Inc()
 
 	// Push the OTP out.
 	if req.To != "" {
-		if req.SendEmail {
-			if err := push(newOTP, req.CodeType, p, req.RootURL, ttl); err != nil {
-				req.Lo.Error("error sending OTP", "error", err, "provider", p.provider.ID())
+		if req.Send {
+			if err := push(ctx, newOTP, req.CodeType, p, req.RootURL, ttl); err != nil {
+				lo.Error("error sending OTP", "error", err, "provider", p.provider.ID())
 				return nil, NewOTPError(SendingOTPFailed, fmt.Sprintf("Error sending OTP %v provider %s", err, p.provider.ID()))
 			}
-			req.Lo.Debug("sending otp", "to", newOTP.To, "provider", p.provider.ID(), "namespace", otp.Namespace)
+			lo.Debug("sending otp", "to", newOTP.To, "provider", p.provider.ID(), "namespace", otp.Namespace)
 		}
 	}
 
@@ -289,31 +376,43 @@ func HandleSetOTP(req SetOTPRequest) (*OTPResp, error) {
 }
 
 // HandleVerifyOTP checks the user input against a stored OTP.
-func HandleVerifyOTP(req *VerifyOTPRequest) (*models.OTP, error) {
-	if len(req.ID) < 6 {
-		req.Lo.Error("ID should be min 6 chars")
+//
+// Deprecated: Use Service.Verify.
+func HandleVerifyOTP(ctx context.Context, req *VerifyOTPRequest) (*models.OTP, error) {
+	return verify(ctx, req.Store, req.Lo, req.Namespace, req.ID, req.OTPVal)
+}
+
+func verify(ctx context.Context, s store.Store, lo *logf.Logger, namespace, id, otpVal string) (*models.OTP, error) {
+	if len(id) < 6 {
+		lo.Error("ID should be min 6 chars")
 		return nil, NewOTPError(OTPErrorUnknown, "ID should be min 6 chars")
 	}
-	if req.OTPVal == "" {
-		req.Lo.Error("`otp` is empty.")
+	if otpVal == "" {
+		lo.Error("`otp` is empty.")
 		return nil, NewOTPError(OTPErrorUnknown, "`otp` is empty.")
 	}
 
-	out, err := verifyOTP(req.Namespace, req.ID, req.OTPVal, true, req.Store, req.Lo)
+	out, err := verifyOTP(ctx, namespace, id, otpVal, true, s, lo)
 	return &out, err
 }
 
 // HandleCheckOTPStatus checks the user input against a stored OTP.
-func HandleCheckOTPStatus(req *CheckOTPStatus) (*models.OTP, error) {
-	if len(req.ID) < 6 {
-		req.Lo.Error("ID should be min 6 chars.")
+//
+// Deprecated: Use Service.Status.
+func HandleCheckOTPStatus(ctx context.Context, req *CheckOTPStatus) (*models.OTP, error) {
+	return status(ctx, req.Store, req.Lo, req.Namespace, req.ID)
+}
+
+func status(ctx context.Context, s store.Store, lo *logf.Logger, namespace, id string) (*models.OTP, error) {
+	if len(id) < 6 {
+		lo.Error("ID should be min 6 chars.")
 		return nil, errors.New("ID should be min 6 chars.")
 	}
 
 	// Check the OTP status.
-	out, err := req.Store.Check(req.Namespace, req.ID, false)
+	out, err := s.Check(ctx, namespace, id, false)
 	if out.Closed {
-		req.Store.Delete(req.Namespace, req.ID)
+		s.Delete(ctx, namespace, id)
 	}
 	return &out, err
 }
@@ -1,6 +1,8 @@
 package otp
 
 import (
+	"errors"
+	"fmt"
 	"github.com/Masterminds/sprig"
 	"github.com/suhailgupta03/thunderbyte/otp/models"
 	"github.com/suhailgupta03/thunderbyte/otp/providers/smtp"
@@ -20,19 +22,16 @@ type provider struct {
 	tpl      *providerTpl
 }
 
-// initProviderTpl loads a provider's optional templates.
-func initProviderTpl(subj, tplFile string, lo *logf.Logger) *providerTpl {
+// parseProviderTpl parses the optional subject template string and body
+// template file.
+func parseProviderTpl(subj, tplFile string) (*providerTpl, error) {
 	out := &providerTpl{}
 
 	// Template file.
 	if tplFile != "" {
-		// Parse the template file.
-		// tpl, err := template.ParseFiles(tplFile)
-
 		tpl, err := template.New(filepath.Base(tplFile)).Funcs(sprig.FuncMap()).ParseFiles(tplFile)
-
 		if err != nil {
-			lo.Fatal("error parsing template file", "tplFile", tplFile, "error", err)
+			return nil, fmt.Errorf("error parsing template file %s: %w", tplFile, err)
 		}
 		out.body = tpl
 	}
@@ -41,35 +40,37 @@ func initProviderTpl(subj, tplFile string, lo *logf.Logger) *providerTpl {
 	if subj != "" {
 		tpl, err := template.New("subject").Parse(subj)
 		if err != nil {
-			lo.Fatal("error parsing template subject", "tplFile", tplFile, "error", err)
+			return nil, fmt.Errorf("error parsing template subject: %w", err)
 		}
-
 		out.subject = tpl
 	}
 
-	return out
+	return out, nil
 }
 
-// initProviders loads models.Provider plugins from the list of given filenames.
-func initProviders(cfg *smtp.Config, templateName string, subject string, lo *logf.Logger) map[string]*provider {
+// initProviders builds the SMTP provider for requests without a Registry.
+func initProviders(cfg *smtp.Config, templateName string, subject string, lo *logf.Logger) (map[string]*provider, error) {
 	out := make(map[string]*provider)
 	// Initialized the in-built providers.
 	// SMTP.
-	smtpEnabled := true
-	if smtpEnabled && cfg != nil {
+	if cfg != nil {
 		p, err := smtp.New(*cfg)
 		if err != nil {
-			lo.Fatal("error initializing smtp provider", "error", err)
+			return nil, fmt.Errorf("error initializing smtp provider: %w", err)
+		}
+		tpl, err := parseProviderTpl(subject, templateName)
+		if err != nil {
+			return nil, err
 		}
 
 		out["smtp"] = &provider{
 			provider: p,
-			tpl:      initProviderTpl(subject, templateName, lo),
+			tpl:      tpl,
 		}
 	}
 
 	if len(out) == 0 {
-		lo.Fatal("no providers or webhooks enabled")
+		return nil, errors.New("no providers or webhooks enabled")
 	}
 
 	names := []string{}
@@ -77,7 +78,7 @@ func initProviders(cfg *smtp.Config, templateName string, subject string, lo *lo
 		names = append(names, name)
 	}
 
-	lo.Info("enabled providers:", strings.Join(names, ", "))
+	lo.Debug("enabled providers:", strings.Join(names, ", "))
 
-	return out
+	return out, nil
 }
@@ -0,0 +1,40 @@
+package otp
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	verifyResultSuccess = "success"
+	verifyResultInvalid = "invalid"
+	verifyResultExpired = "expired"
+	verifyResultLocked  = "locked"
+	verifyResultError   = "error"
+)
+
+var (
+	otpSetTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "thunderbyte",
+		Subsystem: "otp",
+		Name:      "set_total",
+		Help:      "Total number of OTPs created.",
+	}, []string{"namespace", "provider"})
+	otpVerifyTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "thunderbyte",
+		Subsystem: "otp",
+		Name:      "verify_total",
+		Help:      "Total number of OTP verifications by result.",
+	}, []string{"namespace", "result"})
+	otpLockedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "thunderbyte",
+		Subsystem: "otp",
+		Name:      "locked_total",
+		Help:      "Total number of requests rejected because the OTP was locked.",
+	}, []string{"namespace"})
+)
+
+// Collectors returns the Prometheus collectors of the OTP package so
+// that they can be registered on the application's registry. They are
+// shared by every service of the process, so a registry shared by
+// several apps must register them only once.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{otpSetTotal, otpVerifyTotal, otpLockedTotal}
+}
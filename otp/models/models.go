@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 )
@@ -67,7 +68,8 @@ type Provider interface {
 	// Push pushes a message. Depending on the the Provider,
 	// implementation, this can either cause the message to
 	// be sent immediately or be queued waiting for a Flush().
-	Push(otp OTP, subject string, body []byte) error
+	// Implementations should stop when ctx is done.
+	Push(ctx context.Context, otp OTP, subject string, body []byte) error
 
 	// MaxAddressLen returns the maximum allowed length of the 'to' address.
 	MaxAddressLen() int
@@ -1,18 +1,27 @@
 package smtp
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/suhailgupta03/smtppool"
 	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"net/smtp"
 	"regexp"
 	"time"
 )
 
+// ProviderID is the ID the provider is registered under.
+const ProviderID = "smtp"
+
 const (
-	providerID    = "smtp"
+	providerID    = ProviderID
 	channelName   = "E-mail"
 	addressName   = "E-mail ID"
 	maxOTPlen     = 6
@@ -20,6 +29,10 @@ const (
 	maxBodyLen    = 100 * 1024
 )
 
+// tracer records a span for every e-mail sent. It is a no-op
+// unless a tracer provider is registered with otel.
+var tracer = otel.Tracer("github.com/suhailgupta03/thunderbyte/otp/providers/smtp")
+
 // http://www.golangprograms.com/regular-expression-to-validate-email-address.html
 var reMail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
@@ -50,57 +63,17 @@ type SMTP struct {
 
 // New creates and returns an e-mail Provider backend.
 func New(cfg Config) (*SMTP, error) {
-	var pool *smtppool.Pool
-	var err error
-	if cfg.SMTPPoolConnection == nil {
-		if cfg.FromEmail == "" {
-			cfg.FromEmail = "otp@localhost"
-		}
-
-		// Initialize the SMTP mailer.
-		var auth smtp.Auth
-		switch cfg.AuthProtocol {
-		case "login":
-			auth = &smtppool.LoginAuth{Username: cfg.Username, Password: cfg.Password}
-		case "cram":
-			auth = smtp.CRAMMD5Auth(cfg.Username, cfg.Password)
-		case "plain":
-			auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
-		case "", "none":
-		default:
-			return nil, fmt.Errorf("unknown SMTP auth type '%s'", cfg.AuthProtocol)
-		}
-
-		opt := smtppool.Opt{
-			Host:            cfg.Host,
-			Port:            cfg.Port,
-			MaxConns:        cfg.MaxConns,
-			IdleTimeout:     time.Second * 10,
-			PoolWaitTimeout: cfg.Timeout,
-			Auth:            auth,
-		}
-
-		// TLS config.
-		if cfg.TLSType != "none" {
-			opt.TLSConfig = &tls.Config{}
-			if cfg.TLSSkipVerify {
-				opt.TLSConfig.InsecureSkipVerify = cfg.TLSSkipVerify
-			} else {
-				opt.TLSConfig.ServerName = cfg.Host
-			}
-
-			// SSL/TLS, not cfg.
-			if cfg.TLSType == "TLS" {
-				opt.SSL = true
-			}
-		}
+	if cfg.FromEmail == "" {
+		cfg.FromEmail = "otp@localhost"
+	}
 
-		pool, err = smtppool.New(opt)
+	pool := cfg.SMTPPoolConnection
+	if pool == nil {
+		p, err := NewPool(cfg)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		pool = cfg.SMTPPoolConnection
+		pool = p
 	}
 
 	return &SMTP{
@@ -109,6 +82,60 @@ func New(cfg Config) (*SMTP, error) {
 	}, nil
 }
 
+// NewProvider creates the provider from a JSON encoded Config. It
+// implements models.NewProvider.
+func NewProvider(jsonCfg []byte) (models.Provider, error) {
+	var cfg Config
+	if err := json.Unmarshal(jsonCfg, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid smtp config: %w", err)
+	}
+	return New(cfg)
+}
+
+// NewPool creates an SMTP connection pool from the given config. The
+// pool can be shared across providers via Config.SMTPPoolConnection.
+func NewPool(cfg Config) (*smtppool.Pool, error) {
+	// Initialize the SMTP mailer.
+	var auth smtp.Auth
+	switch cfg.AuthProtocol {
+	case "login":
+		auth = &smtppool.LoginAuth{Username: cfg.Username, Password: cfg.Password}
+	case "cram":
+		auth = smtp.CRAMMD5Auth(cfg.Username, cfg.Password)
+	case "plain":
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	case "", "none":
+	default:
+		return nil, fmt.Errorf("unknown SMTP auth type '%s'", cfg.AuthProtocol)
+	}
+
+	opt := smtppool.Opt{
+		Host:            cfg.Host,
+		Port:            cfg.Port,
+		MaxConns:        cfg.MaxConns,
+		IdleTimeout:     time.Second * 10,
+		PoolWaitTimeout: cfg.Timeout,
+		Auth:            auth,
+	}
+
+	// TLS config.
+	if cfg.TLSType != "none" {
+		opt.TLSConfig = &tls.Config{}
+		if cfg.TLSSkipVerify {
+			opt.TLSConfig.InsecureSkipVerify = cfg.TLSSkipVerify
+		} else {
+			opt.TLSConfig.ServerName = cfg.Host
+		}
+
+		// SSL/TLS, not cfg.
+		if cfg.TLSType == "TLS" {
+			opt.SSL = true
+		}
+	}
+
+	return smtppool.New(opt)
+}
+
 // ID returns the Provider's ID.
 func (s *SMTP) ID() string {
 	return providerID
@@ -147,14 +174,31 @@ func (s *SMTP) ValidateAddress(to string) error {
 	return nil
 }
 
-// Push pushes an e-mail to the SMTP server.
-func (s *SMTP) Push(otp models.OTP, subject string, m []byte) error {
-	return s.p.Send(smtppool.Email{
+// Push pushes an e-mail to the SMTP server. The pool cannot abandon a
+// send in flight, so ctx is only checked before the e-mail is handed
+// over.
+func (s *SMTP) Push(ctx context.Context, otp models.OTP, subject string, m []byte) error {
+	ctx, span := tracer.Start(ctx, "smtp.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("otp.namespace", otp.Namespace)))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err := s.p.Send(smtppool.Email{
 		From:    s.cfg.FromEmail,
 		To:      []string{otp.To},
 		Subject: subject,
 		HTML:    m,
 	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
 }
 
 // MaxAddressLen returns the maximum allowed length of the e-mail address.
@@ -0,0 +1,386 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ProviderID is the ID the provider is registered under.
+const ProviderID = "webhook"
+
+const (
+	// BodyFormatJSON sends the rendered template as application/json.
+	BodyFormatJSON = "json"
+	// BodyFormatForm sends the rendered template as
+	// application/x-www-form-urlencoded.
+	BodyFormatForm = "form"
+
+	defaultChannel         = "SMS"
+	defaultOTPLen          = 6
+	defaultTimeout         = 10 * time.Second
+	defaultMaxRetries      = 2
+	defaultRetryWait       = 500 * time.Millisecond
+	defaultSignatureHeader = "X-Signature"
+	timestampHeader        = "X-Signature-Timestamp"
+	maxAddressLen          = 16
+	maxBodyLen             = 4096
+	maxResponseLen         = 64 * 1024
+
+	defaultJSONTemplate = `{"to": {{ json .To }}, "otp": {{ json .OTP }}, "message": {{ json .Body }}}`
+	defaultFormTemplate = `to={{ urlquery .To }}&otp={{ urlquery .OTP }}&message={{ urlquery .Body }}`
+)
+
+// tracer records a span for every webhook call. It is a no-op
+// unless a tracer provider is registered with otel.
+var tracer = otel.Tracer("github.com/suhailgupta03/thunderbyte/otp/providers/webhook")
+
+// reE164 matches phone numbers in the E.164 format, e.g. +919876543210.
+var reE164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// Config represents a webhook endpoint, typically an SMS or WhatsApp
+// gateway.
+type Config struct {
+	// ID is returned by the provider. Set it when more than one webhook
+	// is registered. Defaults to "webhook".
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Method defaults to POST.
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	// BodyFormat is "json" (default) or "form".
+	BodyFormat string `json:"body_format"`
+	// BodyTemplate is a text/template rendered with the fields of
+	// Message. Values have to be escaped with the `json` function for
+	// JSON bodies and with `urlquery` for form bodies.
+	BodyTemplate string `json:"body_template"`
+	// Channel is the name of the channel shown to users. Defaults to "SMS".
+	Channel string `json:"channel"`
+	// OTPLength defaults to 6.
+	OTPLength int `json:"otp_length"`
+	// Timeout is the timeout of a single attempt, e.g. "10s" in JSON.
+	// Defaults to 10 seconds.
+	Timeout time.Duration `json:"timeout"`
+	// MaxRetries is the number of retries after a network error, a 429 or
+	// a 5xx response. Defaults to 2, a negative value disables retries.
+	MaxRetries int `json:"max_retries"`
+	// RetryWait is the wait before the first retry, e.g. "500ms" in JSON.
+	// It doubles on every retry. Defaults to 500ms.
+	RetryWait time.Duration `json:"retry_wait"`
+	// SigningSecret enables HMAC-SHA256 signing of the requests. The
+	// signature of "<timestamp>.<body>" is sent as "sha256=<hex>" in
+	// SignatureHeader and the unix timestamp in X-Signature-Timestamp.
+	SigningSecret string `json:"signing_secret"`
+	// SignatureHeader defaults to X-Signature.
+	SignatureHeader string `json:"signature_header"`
+
+	// Client is used to send the requests when set, e.g. the client of
+	// an httptest server.
+	Client *http.Client `json:"-"`
+}
+
+// UnmarshalJSON decodes the config, accepting Timeout and RetryWait as
+// duration strings such as "10s" or as nanoseconds.
+func (c *Config) UnmarshalJSON(b []byte) error {
+	type config Config
+	aux := struct {
+		*config
+		Timeout   json.RawMessage `json:"timeout"`
+		RetryWait json.RawMessage `json:"retry_wait"`
+	}{config: (*config)(c)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	var err error
+	if c.Timeout, err = parseDuration(aux.Timeout); err != nil {
+		return fmt.Errorf("invalid timeout: %w", err)
+	}
+	if c.RetryWait, err = parseDuration(aux.RetryWait); err != nil {
+		return fmt.Errorf("invalid retry_wait: %w", err)
+	}
+	return nil
+}
+
+// parseDuration decodes a JSON duration string or number of nanoseconds.
+// An absent value is 0.
+func parseDuration(raw json.RawMessage) (time.Duration, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return time.ParseDuration(s)
+	}
+	var n int64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return 0, errors.New("expected a duration string such as \"10s\" or a number of nanoseconds")
+	}
+	return time.Duration(n), nil
+}
+
+// Message is the data the body template is rendered with.
+type Message struct {
+	To        string
+	OTP       string
+	Namespace string
+	ID        string
+	Channel   string
+	// Subject and Body are the rendered templates of the otp package.
+	Subject string
+	Body    string
+}
+
+// Webhook is a generic HTTP provider.
+type Webhook struct {
+	cfg    Config
+	tpl    *template.Template
+	client *http.Client
+}
+
+// NewProvider creates the provider from a JSON encoded Config. It
+// implements models.NewProvider.
+func NewProvider(jsonCfg []byte) (models.Provider, error) {
+	var cfg Config
+	if err := json.Unmarshal(jsonCfg, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid webhook config: %w", err)
+	}
+	return New(cfg)
+}
+
+// New creates and returns a webhook Provider.
+func New(cfg Config) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook url is required")
+	}
+	if cfg.ID == "" {
+		cfg.ID = ProviderID
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	if cfg.BodyFormat == "" {
+		cfg.BodyFormat = BodyFormatJSON
+	}
+	if cfg.Channel == "" {
+		cfg.Channel = defaultChannel
+	}
+	if cfg.OTPLength == 0 {
+		cfg.OTPLength = defaultOTPLen
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.RetryWait == 0 {
+		cfg.RetryWait = defaultRetryWait
+	}
+	if cfg.SignatureHeader == "" {
+		cfg.SignatureHeader = defaultSignatureHeader
+	}
+
+	body := cfg.BodyTemplate
+	switch cfg.BodyFormat {
+	case BodyFormatJSON:
+		if body == "" {
+			body = defaultJSONTemplate
+		}
+	case BodyFormatForm:
+		if body == "" {
+			body = defaultFormTemplate
+		}
+	default:
+		return nil, fmt.Errorf("unknown webhook body format '%s'", cfg.BodyFormat)
+	}
+	tpl, err := template.New("body").Funcs(template.FuncMap{"json": toJSON}).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing webhook body template: %w", err)
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &Webhook{cfg: cfg, tpl: tpl, client: client}, nil
+}
+
+// toJSON encodes a value to be embedded in a JSON body template.
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// ID returns the Provider's ID.
+func (w *Webhook) ID() string {
+	return w.cfg.ID
+}
+
+// ChannelName returns the name of the channel, e.g. SMS or WhatsApp.
+func (w *Webhook) ChannelName() string {
+	return w.cfg.Channel
+}
+
+// ChannelDesc returns help text for the webhook Provider.
+func (w *Webhook) ChannelDesc() string {
+	return fmt.Sprintf(`
+	A %d digit code has been sent to your phone via %s.
+	Enter the code here to complete the verification.`, w.cfg.OTPLength, w.cfg.Channel)
+}
+
+// AddressName returns the webhook Provider's address name.
+func (w *Webhook) AddressName() string {
+	return "Phone number"
+}
+
+// AddressDesc returns the help text that is shown to the end users when
+// they're asked to enter their phone number.
+func (w *Webhook) AddressDesc() string {
+	return `Please enter your phone number with the country code, e.g. +919876543210`
+}
+
+// ValidateAddress checks that the address is an E.164 phone number.
+func (w *Webhook) ValidateAddress(to string) error {
+	if !reE164.MatchString(to) {
+		return errors.New("invalid phone number. It has to be in the E.164 format, e.g. +919876543210")
+	}
+	return nil
+}
+
+// Push renders the body template and posts it to the webhook URL,
+// retrying on network errors, 429 and 5xx responses until ctx is done.
+func (w *Webhook) Push(ctx context.Context, otp models.OTP, subject string, m []byte) error {
+	ctx, span := tracer.Start(ctx, "webhook.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("otp.namespace", otp.Namespace),
+			attribute.String("otp.provider", w.cfg.ID)))
+	defer span.End()
+
+	err := w.push(ctx, otp, subject, m)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (w *Webhook) push(ctx context.Context, otp models.OTP, subject string, m []byte) error {
+	body := &bytes.Buffer{}
+	if err := w.tpl.Execute(body, Message{
+		To:        otp.To,
+		OTP:       otp.OTP,
+		Namespace: otp.Namespace,
+		ID:        otp.ID,
+		Channel:   w.cfg.Channel,
+		Subject:   subject,
+		Body:      string(m),
+	}); err != nil {
+		return fmt.Errorf("error rendering webhook body: %w", err)
+	}
+	if w.cfg.BodyFormat == BodyFormatJSON && !json.Valid(body.Bytes()) {
+		return errors.New("webhook body template did not render valid JSON")
+	}
+
+	wait := w.cfg.RetryWait
+	var err error
+	for attempt := 0; attempt <= w.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			wait *= 2
+		}
+		var retry bool
+		retry, err = w.send(ctx, body.Bytes())
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// send makes a single attempt. It tells whether a failure is worth
+// retrying.
+func (w *Webhook) send(ctx context.Context, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, w.cfg.Method, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	if w.cfg.BodyFormat == BodyFormatForm {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.cfg.SigningSecret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(timestampHeader, ts)
+		req.Header.Set(w.cfg.SignatureHeader, "sha256="+Sign(w.cfg.SigningSecret, ts, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseLen))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>". The
+// receiving end recomputes it to authenticate the request.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MaxAddressLen returns the maximum length of an E.164 phone number.
+func (w *Webhook) MaxAddressLen() int {
+	return maxAddressLen
+}
+
+// MaxOTPLen returns the length of the OTP value.
+func (w *Webhook) MaxOTPLen() int {
+	return w.cfg.OTPLength
+}
+
+// MaxBodyLen returns the max permitted body size.
+func (w *Webhook) MaxBodyLen() int {
+	return maxBodyLen
+}
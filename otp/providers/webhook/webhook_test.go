@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"context"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newServer returns a webhook server answering with the given statuses in
+// turn, repeating the last one, and a counter of the requests it got.
+func newServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func newWebhook(t *testing.T, cfg Config) *Webhook {
+	t.Helper()
+	if cfg.RetryWait == 0 {
+		cfg.RetryWait = time.Millisecond
+	}
+	w, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+var testOTP = models.OTP{Namespace: "ns", ID: "id", OTP: "123456", To: "+919876543210"}
+
+func TestPushRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		wantCall int32
+	}{
+		{"success", []int{http.StatusOK}, false, 1},
+		{"retry on 5xx", []int{http.StatusBadGateway, http.StatusOK}, false, 2},
+		{"retry on 429", []int{http.StatusTooManyRequests, http.StatusOK}, false, 2},
+		{"no retry on 4xx", []int{http.StatusBadRequest, http.StatusOK}, true, 1},
+		{"retries exhausted", []int{http.StatusServiceUnavailable}, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := newServer(t, tt.statuses...)
+			w := newWebhook(t, Config{URL: srv.URL, MaxRetries: 2})
+
+			err := w.Push(context.Background(), testOTP, "subject", []byte("body"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := calls.Load(); got != tt.wantCall {
+				t.Errorf("got %d requests, want %d", got, tt.wantCall)
+			}
+		})
+	}
+}
+
+func TestPushSignature(t *testing.T) {
+	const secret = "s3cret"
+	var body []byte
+	var ts, sig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		ts = r.Header.Get(timestampHeader)
+		sig = r.Header.Get("X-Hub-Signature")
+	}))
+	t.Cleanup(srv.Close)
+
+	w := newWebhook(t, Config{URL: srv.URL, SigningSecret: secret, SignatureHeader: "X-Hub-Signature"})
+	if err := w.Push(context.Background(), testOTP, "subject", []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	if ts == "" {
+		t.Fatal("missing timestamp header")
+	}
+	if want := "sha256=" + Sign(secret, ts, body); sig != want {
+		t.Errorf("signature = %q, want %q", sig, want)
+	}
+	if !strings.Contains(string(body), `"otp": "123456"`) {
+		t.Errorf("unexpected body %s", body)
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	w := newWebhook(t, Config{URL: "http://localhost"})
+	for _, to := range []string{"+919876543210", "+12025550123"} {
+		if err := w.ValidateAddress(to); err != nil {
+			t.Errorf("ValidateAddress(%q) = %v", to, err)
+		}
+	}
+	for _, to := range []string{"", "919876543210", "+0123456", "+1", "+1234567890123456", "+91 98765 43210", "+91abc"} {
+		if err := w.ValidateAddress(to); err == nil {
+			t.Errorf("ValidateAddress(%q) accepted an invalid number", to)
+		}
+	}
+}
+
+func TestConfigDurations(t *testing.T) {
+	tests := []struct {
+		json      string
+		timeout   time.Duration
+		retryWait time.Duration
+		wantErr   bool
+	}{
+		{`{"timeout": "10s", "retry_wait": "250ms"}`, 10 * time.Second, 250 * time.Millisecond, false},
+		{`{"timeout": 2000000000}`, 2 * time.Second, 0, false},
+		{`{"url": "http://localhost"}`, 0, 0, false},
+		{`{"timeout": "ten"}`, 0, 0, true},
+		{`{"retry_wait": true}`, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var cfg Config
+			err := cfg.UnmarshalJSON([]byte(tt.json))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.Timeout != tt.timeout || cfg.RetryWait != tt.retryWait {
+				t.Errorf("got timeout %v and retry wait %v, want %v and %v", cfg.Timeout, cfg.RetryWait, tt.timeout, tt.retryWait)
+			}
+		})
+	}
+
+	p, err := NewProvider([]byte(`{"url": "http://localhost", "timeout": "3s"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.(*Webhook).cfg.Timeout; got != 3*time.Second {
+		t.Errorf("NewProvider timeout = %v, want 3s", got)
+	}
+}
@@ -0,0 +1,171 @@
+package otp
+
+import (
+	"fmt"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/providers/smtp"
+	"github.com/suhailgupta03/thunderbyte/otp/providers/webhook"
+	"sort"
+	"sync"
+)
+
+// Registry holds the providers selectable by SetOTPRequest.Provider.
+// Constructors are registered by ID and the providers are built once by
+// Init, usually at startup.
+type Registry struct {
+	mu           sync.RWMutex
+	constructors map[string]models.NewProvider
+	providers    map[string]*provider
+	templates    map[[2]string]*providerTpl
+}
+
+// NewRegistry returns a registry with the built-in providers registered.
+func NewRegistry() *Registry {
+	r := &Registry{
+		constructors: make(map[string]models.NewProvider),
+		providers:    make(map[string]*provider),
+		templates:    make(map[[2]string]*providerTpl),
+	}
+	r.constructors[smtp.ProviderID] = smtp.NewProvider
+	r.constructors[webhook.ProviderID] = webhook.NewProvider
+	return r
+}
+
+// Register adds a provider constructor under the ID. The constructor
+// receives the JSON config given to Init.
+func (r *Registry) Register(id string, fn models.NewProvider) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.constructors[id]; ok {
+		return fmt.Errorf("provider '%s' is already registered", id)
+	}
+	r.constructors[id] = fn
+	return nil
+}
+
+// Init builds the configured providers. Providers registered but absent
+// from configs are not enabled.
+func (r *Registry) Init(configs map[string]models.ProviderConfig) error {
+	ids := make([]string, 0, len(configs))
+	for id := range configs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		r.mu.RLock()
+		fn, ok := r.constructors[id]
+		r.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("provider '%s' is not registered", id)
+		}
+		p, err := fn([]byte(configs[id].Config))
+		if err != nil {
+			return fmt.Errorf("error initializing provider '%s': %w", id, err)
+		}
+		if err := r.Add(id, p, configs[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add enables an already built provider under the ID. It is meant for
+// providers holding resources that cannot be expressed as JSON, e.g. a
+// shared SMTP pool. cfg.Config is ignored.
+func (r *Registry) Add(id string, p models.Provider, cfg models.ProviderConfig) error {
+	tpl, err := r.loadTemplate(cfg.Subject, cfg.Template)
+	if err != nil {
+		return fmt.Errorf("error loading templates of provider '%s': %w", id, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.providers[id]; ok {
+		return fmt.Errorf("provider '%s' is already enabled", id)
+	}
+	r.providers[id] = &provider{provider: p, tpl: tpl}
+	return nil
+}
+
+// IDs returns the IDs of the enabled providers.
+func (r *Registry) IDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.providers))
+	for id := range r.providers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (r *Registry) get(id string) (*provider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.providers[id]
+	return p, ok
+}
+
+// AddTemplate parses a subject template and a body template file that
+// requests can then select as an override through SetRequest.Subject and
+// SetRequest.Template. Either one may be empty. Overrides that were not
+// added are rejected so that the parsed templates stay bounded.
+func (r *Registry) AddTemplate(subject, file string) error {
+	if _, err := r.loadTemplate(subject, file); err != nil {
+		return fmt.Errorf("error loading templates: %w", err)
+	}
+	return nil
+}
+
+// loadTemplate parses the subject and body templates once and caches them.
+func (r *Registry) loadTemplate(subject, file string) (*providerTpl, error) {
+	if tpl, err := r.template(subject, file); err == nil {
+		return tpl, nil
+	}
+	tpl, err := parseProviderTpl(subject, file)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.templates[[2]string{subject, file}] = tpl
+	r.mu.Unlock()
+	return tpl, nil
+}
+
+// template returns the cached templates of a pair added by AddTemplate
+// or Add.
+func (r *Registry) template(subject, file string) (*providerTpl, error) {
+	r.mu.RLock()
+	tpl, ok := r.templates[[2]string{subject, file}]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("subject %q and template %q are not registered", subject, file)
+	}
+	return tpl, nil
+}
+
+// providerFor returns the provider with the ID. The subject and template
+// file, when set, take precedence over the ones of the provider and must
+// have been added with AddTemplate. Each one is overridden on its own, so
+// a request with only a subject keeps the body template of the provider.
+func (r *Registry) providerFor(id, subject, templateFile string) (*provider, error) {
+	p, ok := r.get(id)
+	if !ok {
+		return nil, NewOTPError(ProviderNotSupported, fmt.Sprintf("%s provider not supported. Failed to set OTP", id))
+	}
+	if subject == "" && templateFile == "" {
+		return p, nil
+	}
+	tpl, err := r.template(subject, templateFile)
+	if err != nil {
+		return nil, NewOTPError(OTPErrorUnknown, fmt.Sprintf("error loading templates %v", err))
+	}
+	merged := *tpl
+	if merged.subject == nil {
+		merged.subject = p.tpl.subject
+	}
+	if merged.body == nil {
+		merged.body = p.tpl.body
+	}
+	return &provider{provider: p.provider, tpl: &merged}, nil
+}
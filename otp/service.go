@@ -0,0 +1,86 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/store"
+	"github.com/zerodha/logf"
+)
+
+// ServiceConfig holds what a Service is built from.
+type ServiceConfig struct {
+	// Registry holds the providers. When nil a new registry is created
+	// and Providers are initialized on it.
+	Registry *Registry
+	// Providers are the providers to enable, keyed by the ID they are
+	// registered under.
+	Providers map[string]models.ProviderConfig
+	Store     store.Store
+	Logger    *logf.Logger
+}
+
+// Service sets and verifies OTPs. It is built once at startup and owns
+// the providers, their parsed templates and the store.
+type Service struct {
+	registry *Registry
+	store    store.Store
+	lo       *logf.Logger
+}
+
+// NewService initializes the providers and returns the service. Errors
+// in the provider configs or templates are returned here rather than on
+// the first request.
+func NewService(cfg ServiceConfig) (*Service, error) {
+	if cfg.Store == nil {
+		return nil, errors.New("an OTP store is required")
+	}
+	if cfg.Logger == nil {
+		lo := logf.New(logf.Opts{})
+		cfg.Logger = &lo
+	}
+	registry := cfg.Registry
+	if registry == nil {
+		registry = NewRegistry()
+	}
+	if len(cfg.Providers) > 0 {
+		if err := registry.Init(cfg.Providers); err != nil {
+			return nil, err
+		}
+	}
+	return &Service{registry: registry, store: cfg.Store, lo: cfg.Logger}, nil
+}
+
+// Registry returns the providers of the service.
+func (s *Service) Registry() *Registry {
+	return s.registry
+}
+
+// Store returns the store of the service.
+func (s *Service) Store() store.Store {
+	return s.store
+}
+
+// Set creates a new OTP while respecting maximum attempts and TTL values
+// and pushes it to the provider of the request. ctx bounds the calls to
+// the store and the provider.
+func (s *Service) Set(ctx context.Context, req SetRequest) (*OTPResp, error) {
+	p, err := s.registry.providerFor(req.Provider, req.Subject, req.Template)
+	if err != nil {
+		s.lo.Error("Failed to set OTP", "provider", req.Provider, "error", err)
+		return nil, err
+	}
+	return setOTP(ctx, p, s.store, s.lo, req)
+}
+
+// Verify checks the user input against a stored OTP. A verified OTP is
+// deleted.
+func (s *Service) Verify(ctx context.Context, namespace, id, otp string) (*models.OTP, error) {
+	return verify(ctx, s.store, s.lo, namespace, id, otp)
+}
+
+// Status returns a stored OTP without counting an attempt. A closed OTP
+// is deleted once its status is read.
+func (s *Service) Status(ctx context.Context, namespace, id string) (*models.OTP, error) {
+	return status(ctx, s.store, s.lo, namespace, id)
+}
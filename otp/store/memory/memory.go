@@ -0,0 +1,257 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/store"
+	"sync"
+	"time"
+)
+
+// defaultSweepInterval is how often expired OTPs are removed when no
+// interval is configured.
+const defaultSweepInterval = time.Minute
+
+// Conf contains the in-memory store configuration fields.
+type Conf struct {
+	// SweepInterval is how often expired OTPs are removed from memory.
+	// Expired OTPs are never returned regardless of this value.
+	SweepInterval time.Duration
+	// Now returns the current time. It defaults to time.Now and can be
+	// replaced to control expiry in tests.
+	Now func() time.Time
+}
+
+// Memory implements a Store that keeps OTPs in the memory of the
+// process. It is meant for tests and single-node deployments; OTPs are
+// lost on restart and are not shared between instances.
+type Memory struct {
+	conf Conf
+
+	mu        sync.Mutex
+	otps      map[string]*entry
+	lastSweep time.Time
+
+	subMu sync.RWMutex
+	subs  map[chan store.Event]struct{}
+}
+
+type entry struct {
+	otp       models.OTP
+	expiresAt time.Time
+}
+
+// New returns an in-memory implementation of store.
+func New(c Conf) *Memory {
+	if c.SweepInterval <= 0 {
+		c.SweepInterval = defaultSweepInterval
+	}
+	if c.Now == nil {
+		c.Now = time.Now
+	}
+	return &Memory{
+		conf:      c,
+		otps:      make(map[string]*entry),
+		lastSweep: c.Now(),
+		subs:      make(map[chan store.Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives the 'check' and 'close'
+// events, the equivalent of the Redis store's PublishKey. Events are
+// dropped when the buffer of the channel is full so that a slow
+// subscriber never blocks the store.
+func (m *Memory) Subscribe(buffer int) <-chan store.Event {
+	ch := make(chan store.Event, buffer)
+	m.subMu.Lock()
+	m.subs[ch] = struct{}{}
+	m.subMu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops sending events to a channel returned by Subscribe
+// and closes it.
+func (m *Memory) Unsubscribe(ch <-chan store.Event) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for c := range m.subs {
+		if c == ch {
+			delete(m.subs, c)
+			close(c)
+			return
+		}
+	}
+}
+
+// Ping always succeeds as there is nothing to reach.
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Check checks the attempt count and TTL duration against an ID.
+// Passing counter=true increments the attempt counter.
+func (m *Memory) Check(ctx context.Context, namespace, id string, counter bool) (models.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return models.OTP{Namespace: namespace, ID: id}, err
+	}
+	m.mu.Lock()
+	e, ok := m.get(namespace, id)
+	if !ok {
+		m.mu.Unlock()
+		return models.OTP{Namespace: namespace, ID: id}, store.ErrNotExist
+	}
+	if counter {
+		e.otp.Attempts++
+	}
+	out := m.snapshot(e)
+	m.mu.Unlock()
+
+	if counter {
+		b, _ := json.Marshal(out)
+		m.publish(store.Event{
+			Type:      store.EventCheck,
+			Namespace: namespace,
+			ID:        id,
+			Data:      json.RawMessage(b),
+		})
+	}
+	return out, nil
+}
+
+// Set sets an OTP against an ID. Every Set() increments the attempts
+// count against the ID that was initially set and resets its TTL.
+func (m *Memory) Set(ctx context.Context, namespace, id string, otp models.OTP) (models.OTP, error) {
+	if err := ctx.Err(); err != nil {
+		return otp, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep()
+	attempts := 0
+	if e, ok := m.get(namespace, id); ok {
+		attempts = e.otp.Attempts
+	}
+
+	otp.Namespace = namespace
+	otp.ID = id
+	otp.Attempts = attempts + 1
+	otp.Closed = false
+	otp.Extra = append(json.RawMessage(nil), otp.Extra...)
+	m.otps[makeKey(namespace, id)] = &entry{
+		otp:       otp,
+		expiresAt: m.conf.Now().Add(otp.TTL),
+	}
+
+	otp.TTLSeconds = otp.TTL.Seconds()
+	return otp, nil
+}
+
+// SetAddress sets (updates) the address on an existing OTP.
+func (m *Memory) SetAddress(ctx context.Context, namespace, id, address string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(namespace, id)
+	if !ok {
+		return store.ErrNotExist
+	}
+	e.otp.To = address
+	return nil
+}
+
+// Close closes an OTP and marks it as done (verified).
+// After this, the OTP has to expire after a TTL or be deleted.
+func (m *Memory) Close(ctx context.Context, namespace, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	e, ok := m.get(namespace, id)
+	if !ok {
+		m.mu.Unlock()
+		return store.ErrNotExist
+	}
+	e.otp.Closed = true
+	m.mu.Unlock()
+
+	m.publish(store.Event{
+		Type:      store.EventClose,
+		Namespace: namespace,
+		ID:        id,
+		Data:      json.RawMessage(`null`),
+	})
+	return nil
+}
+
+// Delete deletes the OTP saved against a given ID.
+func (m *Memory) Delete(ctx context.Context, namespace, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.otps, makeKey(namespace, id))
+	m.mu.Unlock()
+	return nil
+}
+
+// get returns the live entry of an OTP, deleting it if it has expired.
+// The caller must hold m.mu.
+func (m *Memory) get(namespace, id string) (*entry, bool) {
+	key := makeKey(namespace, id)
+	e, ok := m.otps[key]
+	if !ok {
+		return nil, false
+	}
+	if !m.conf.Now().Before(e.expiresAt) {
+		delete(m.otps, key)
+		return nil, false
+	}
+	return e, true
+}
+
+// snapshot returns a copy of the OTP of an entry with its remaining TTL.
+// The caller must hold m.mu.
+func (m *Memory) snapshot(e *entry) models.OTP {
+	out := e.otp
+	out.Extra = append(json.RawMessage(nil), e.otp.Extra...)
+	out.TTL = e.expiresAt.Sub(m.conf.Now())
+	out.TTLSeconds = out.TTL.Seconds()
+	return out
+}
+
+// sweep removes the expired OTPs if the sweep interval has elapsed.
+// The caller must hold m.mu.
+func (m *Memory) sweep() {
+	now := m.conf.Now()
+	if now.Sub(m.lastSweep) < m.conf.SweepInterval {
+		return
+	}
+	for key, e := range m.otps {
+		if !now.Before(e.expiresAt) {
+			delete(m.otps, key)
+		}
+	}
+	m.lastSweep = now
+}
+
+// publish sends an event to every subscriber without blocking.
+func (m *Memory) publish(e store.Event) {
+	m.subMu.RLock()
+	defer m.subMu.RUnlock()
+	for ch := range m.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// makeKey makes the map key for the OTP.
+func makeKey(namespace, id string) string {
+	return namespace + ":" + id
+}
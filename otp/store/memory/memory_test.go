@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"github.com/suhailgupta03/thunderbyte/otp/store/storetest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock the tests move forward by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Backend {
+		clock := &fakeClock{now: time.Unix(1700000000, 0)}
+		m := New(Conf{Now: clock.Now})
+		events := m.Subscribe(16)
+		t.Cleanup(func() { m.Unsubscribe(events) })
+		return storetest.Backend{
+			Store:   m,
+			Advance: clock.Advance,
+			Events:  events,
+		}
+	})
+}
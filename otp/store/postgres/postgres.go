@@ -0,0 +1,451 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/store"
+	"github.com/zerodha/logf"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTable         = "otp_store"
+	defaultSweepInterval = time.Minute
+
+	// The pq.Listener reconnects with a back off between these values.
+	minReconnectInterval = 100 * time.Millisecond
+	maxReconnectInterval = time.Minute
+)
+
+// Conf contains the Postgres store configuration fields.
+type Conf struct {
+	// Table is the table the OTPs are stored in. It is created if it
+	// does not exist. Defaults to "otp_store".
+	Table string `json:"table"`
+	// SweepInterval is how often expired OTPs are deleted. Expired OTPs
+	// are never returned regardless of this value. Defaults to a minute.
+	SweepInterval time.Duration `json:"sweep_interval"`
+	// If this is set, 'check' and 'close' events are sent with NOTIFY
+	// on this channel, the equivalent of the Redis store's PublishKey.
+	NotifyChannel string `json:"notify_channel"`
+}
+
+// Postgres implements a Store on top of a PostgreSQL database.
+type Postgres struct {
+	conf    Conf
+	dbc     *database.DBConfig
+	db      *sqlx.DB
+	lo      *logf.Logger
+	queries queries
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+
+	subMu    sync.Mutex
+	listener *pq.Listener
+	subs     map[chan store.Event]struct{}
+}
+
+type queries struct {
+	set        string
+	get        string
+	check      string
+	setAddress string
+	close      string
+	delete     string
+	sweep      string
+}
+
+// row is an OTP as it is stored in the table.
+type row struct {
+	OTP         string  `db:"otp"`
+	To          string  `db:"to"`
+	ChannelDesc string  `db:"channel_description"`
+	AddressDesc string  `db:"address_description"`
+	Extra       string  `db:"extra"`
+	Provider    string  `db:"provider"`
+	MaxAttempts int     `db:"max_attempts"`
+	Attempts    int     `db:"attempts"`
+	Closed      bool    `db:"closed"`
+	TTLSeconds  float64 `db:"ttl_seconds"`
+}
+
+// New returns a Postgres implementation of store. The database must
+// be connected with database.ForRoot. The table is created if it does
+// not exist and a sweeper deleting the expired OTPs is started; call
+// Stop to end it. The app calls Stop on shutdown when the store is set
+// as FactoryCreate.OTPStore.
+func New(dbc *database.DBConfig, c Conf, lo *logf.Logger) (*Postgres, error) {
+	if dbc == nil || dbc.GetDB() == nil {
+		return nil, errors.New("the database is not connected")
+	}
+	if c.Table == "" {
+		c.Table = defaultTable
+	}
+	if c.SweepInterval <= 0 {
+		c.SweepInterval = defaultSweepInterval
+	}
+	if lo == nil {
+		l := logf.New(logf.Opts{})
+		lo = &l
+	}
+
+	p := &Postgres{
+		conf:    c,
+		dbc:     dbc,
+		db:      dbc.GetDB(),
+		lo:      lo,
+		queries: makeQueries(pq.QuoteIdentifier(c.Table)),
+		stop:    make(chan struct{}),
+		subs:    make(map[chan store.Event]struct{}),
+	}
+	if err := p.install(); err != nil {
+		return nil, err
+	}
+
+	p.wg.Add(1)
+	go p.sweeper()
+	return p, nil
+}
+
+// install creates the table of the store. It is idempotent.
+func (p *Postgres) install() error {
+	table := pq.QuoteIdentifier(p.conf.Table)
+	index := pq.QuoteIdentifier(p.conf.Table + "_expires_at_idx")
+	_, err := p.db.Exec(fmt.Sprintf(`create table if not exists %s (
+    namespace text not null,
+    id text not null,
+    otp text not null,
+    "to" text not null default '',
+    channel_description text not null default '',
+    address_description text not null default '',
+    extra text not null default '',
+    provider text not null default '',
+    max_attempts integer not null default 0,
+    attempts integer not null default 0,
+    closed boolean not null default false,
+    expires_at timestamptz not null,
+    primary key (namespace, id)
+);
+
+create index if not exists %s on %s (expires_at);`, table, index, table))
+	if err != nil {
+		return fmt.Errorf("creating the OTP table: %w", err)
+	}
+	return nil
+}
+
+func makeQueries(table string) queries {
+	const columns = `otp, "to", channel_description, address_description, extra, provider,
+	max_attempts, attempts, closed,
+	greatest(extract(epoch from expires_at - now()), 0)::float8 as ttl_seconds`
+
+	return queries{
+		// An expired row that has not been swept yet is replaced as if it
+		// did not exist, so its attempts are not carried over.
+		set: fmt.Sprintf(`INSERT INTO %[1]s AS t (namespace, id, otp, "to", channel_description,
+	address_description, extra, provider, max_attempts, attempts, closed, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 1, false, now() + $10 * interval '1 millisecond')
+ON CONFLICT (namespace, id) DO UPDATE SET
+	otp = excluded.otp,
+	"to" = excluded."to",
+	channel_description = excluded.channel_description,
+	address_description = excluded.address_description,
+	extra = excluded.extra,
+	provider = excluded.provider,
+	max_attempts = excluded.max_attempts,
+	attempts = CASE WHEN t.expires_at > now() THEN t.attempts + 1 ELSE 1 END,
+	closed = false,
+	expires_at = excluded.expires_at
+RETURNING attempts;`, table),
+		get: fmt.Sprintf(`SELECT %s FROM %s
+	where namespace = $1 and id = $2 and expires_at > now();`, columns, table),
+		check: fmt.Sprintf(`UPDATE %s SET attempts = attempts + 1
+	where namespace = $1 and id = $2 and expires_at > now()
+	RETURNING %s;`, table, columns),
+		setAddress: fmt.Sprintf(`UPDATE %s SET "to" = $3
+	where namespace = $1 and id = $2 and expires_at > now();`, table),
+		close: fmt.Sprintf(`UPDATE %s SET closed = true
+	where namespace = $1 and id = $2 and expires_at > now();`, table),
+		delete: fmt.Sprintf(`DELETE FROM %s where namespace = $1 and id = $2;`, table),
+		sweep:  fmt.Sprintf(`DELETE FROM %s where expires_at <= now();`, table),
+	}
+}
+
+// Ping checks if the database is reachable
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// Check checks the attempt count and TTL duration against an ID.
+// Passing counter=true increments the attempt counter.
+func (p *Postgres) Check(ctx context.Context, namespace, id string, counter bool) (models.OTP, error) {
+	out := models.OTP{
+		Namespace: namespace,
+		ID:        id,
+	}
+
+	query := p.queries.get
+	if counter {
+		// The increment and the read are a single statement so that
+		// concurrent checks cannot lose attempts.
+		query = p.queries.check
+	}
+	var r row
+	if err := p.db.GetContext(ctx, &r, query, namespace, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return out, store.ErrNotExist
+		}
+		return out, err
+	}
+	out = r.toOTP(namespace, id)
+
+	if counter && p.conf.NotifyChannel != "" {
+		b, _ := json.Marshal(out)
+		if err := p.notify(ctx, store.Event{
+			Type:      store.EventCheck,
+			Namespace: namespace,
+			ID:        id,
+			Data:      json.RawMessage(b),
+		}); err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// Set sets an OTP against an ID. Every Set() increments the attempts
+// count against the ID that was initially set and resets its TTL.
+func (p *Postgres) Set(ctx context.Context, namespace, id string, otp models.OTP) (models.OTP, error) {
+	var attempts int
+	err := p.db.GetContext(ctx, &attempts, p.queries.set, namespace, id,
+		otp.OTP, otp.To, otp.ChannelDesc, otp.AddressDesc, string(otp.Extra), otp.Provider,
+		otp.MaxAttempts, otp.TTL.Milliseconds())
+	if err != nil {
+		return otp, err
+	}
+
+	otp.Attempts = attempts
+	otp.Closed = false
+	otp.TTLSeconds = otp.TTL.Seconds()
+	otp.Namespace = namespace
+	otp.ID = id
+	return otp, nil
+}
+
+// SetAddress sets (updates) the address on an existing OTP.
+func (p *Postgres) SetAddress(ctx context.Context, namespace, id, address string) error {
+	return p.exec(ctx, p.queries.setAddress, namespace, id, address)
+}
+
+// Close closes an OTP and marks it as done (verified).
+// After this, the OTP has to expire after a TTL or be deleted.
+func (p *Postgres) Close(ctx context.Context, namespace, id string) error {
+	if err := p.exec(ctx, p.queries.close, namespace, id); err != nil {
+		return err
+	}
+
+	if p.conf.NotifyChannel != "" {
+		return p.notify(ctx, store.Event{
+			Type:      store.EventClose,
+			Namespace: namespace,
+			ID:        id,
+			Data:      json.RawMessage(`null`),
+		})
+	}
+	return nil
+}
+
+// Delete deletes the OTP saved against a given ID.
+func (p *Postgres) Delete(ctx context.Context, namespace, id string) error {
+	_, err := p.db.ExecContext(ctx, p.queries.delete, namespace, id)
+	return err
+}
+
+// Subscribe returns a channel that receives the 'check' and 'close'
+// events sent on NotifyChannel. A single LISTEN connection, opened on
+// the first call, is shared by every subscriber. Events are dropped
+// when the buffer of the channel is full so that a slow subscriber
+// never blocks the others. It fails once Stop has been called.
+func (p *Postgres) Subscribe(buffer int) (<-chan store.Event, error) {
+	if p.conf.NotifyChannel == "" {
+		return nil, errors.New("no notify channel configured")
+	}
+
+	p.subMu.Lock()
+	defer p.subMu.Unlock()
+	// Stop closes p.stop before it takes subMu, so no listener is started
+	// and no channel is added once it has begun.
+	select {
+	case <-p.stop:
+		return nil, errors.New("the store is stopped")
+	default:
+	}
+	if p.listener == nil {
+		l := pq.NewListener(p.dbc.DSN(), minReconnectInterval, maxReconnectInterval,
+			func(ev pq.ListenerEventType, err error) {
+				if err != nil {
+					p.lo.Error("OTP store listener error", "error", err)
+				}
+			})
+		if err := l.Listen(p.conf.NotifyChannel); err != nil {
+			l.Close()
+			return nil, err
+		}
+		p.listener = l
+		p.wg.Add(1)
+		go p.listen(l)
+	}
+
+	ch := make(chan store.Event, buffer)
+	p.subs[ch] = struct{}{}
+	return ch, nil
+}
+
+// Unsubscribe stops sending events to a channel returned by Subscribe
+// and closes it.
+func (p *Postgres) Unsubscribe(ch <-chan store.Event) {
+	p.subMu.Lock()
+	defer p.subMu.Unlock()
+	for c := range p.subs {
+		if c == ch {
+			delete(p.subs, c)
+			close(c)
+			return
+		}
+	}
+}
+
+// Stop ends the sweeper, closes the LISTEN connection and the channels
+// of the subscribers. The database itself is not closed.
+func (p *Postgres) Stop() error {
+	var err error
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		p.subMu.Lock()
+		l := p.listener
+		p.subMu.Unlock()
+		if l != nil {
+			err = l.Close()
+		}
+		p.wg.Wait()
+
+		p.subMu.Lock()
+		for c := range p.subs {
+			delete(p.subs, c)
+			close(c)
+		}
+		p.subMu.Unlock()
+	})
+	return err
+}
+
+// exec runs a statement updating a single OTP and returns
+// store.ErrNotExist if there is no such OTP.
+func (p *Postgres) exec(ctx context.Context, query string, args ...interface{}) error {
+	res, err := p.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store.ErrNotExist
+	}
+	return nil
+}
+
+// notify sends an event on NotifyChannel.
+func (p *Postgres) notify(ctx context.Context, e store.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, "select pg_notify($1, $2)", p.conf.NotifyChannel, string(b))
+	return err
+}
+
+// sweeper periodically deletes the expired OTPs until Stop is called.
+func (p *Postgres) sweeper() {
+	defer p.wg.Done()
+	t := time.NewTicker(p.conf.SweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+			if _, err := p.db.Exec(p.queries.sweep); err != nil {
+				p.lo.Error("Failed to delete expired OTPs", "error", err)
+			}
+		}
+	}
+}
+
+// listen fans the notifications of the listener out to the subscribers.
+func (p *Postgres) listen(l *pq.Listener) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case n, ok := <-l.Notify:
+			if !ok {
+				return
+			}
+			// A nil notification is sent after the connection has been
+			// re-established. Events sent in between are lost.
+			if n == nil {
+				continue
+			}
+			var e store.Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				p.lo.Error("Failed to decode OTP store event", "error", err)
+				continue
+			}
+			p.publish(e)
+		}
+	}
+}
+
+// publish sends an event to every subscriber without blocking.
+func (p *Postgres) publish(e store.Event) {
+	p.subMu.Lock()
+	defer p.subMu.Unlock()
+	for ch := range p.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (r row) toOTP(namespace, id string) models.OTP {
+	ttl := time.Duration(r.TTLSeconds * float64(time.Second))
+	return models.OTP{
+		Namespace:   namespace,
+		ID:          id,
+		To:          r.To,
+		ChannelDesc: r.ChannelDesc,
+		AddressDesc: r.AddressDesc,
+		Extra:       json.RawMessage(r.Extra),
+		Provider:    r.Provider,
+		OTP:         r.OTP,
+		MaxAttempts: r.MaxAttempts,
+		Attempts:    r.Attempts,
+		Closed:      r.Closed,
+		TTL:         ttl,
+		TTLSeconds:  ttl.Seconds(),
+	}
+}
@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/suhailgupta03/thunderbyte/database"
+	"github.com/suhailgupta03/thunderbyte/database/dbtest"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/store"
+	"github.com/suhailgupta03/thunderbyte/otp/store/storetest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tableSeq makes the table and channel of every store unique, so that the
+// tests neither share OTPs nor receive each other's notifications.
+var tableSeq atomic.Int64
+
+// newTestStore returns a store on its own table, which is dropped when the
+// test ends.
+func newTestStore(t *testing.T, dbc *database.DBConfig, c Conf) *Postgres {
+	t.Helper()
+	name := fmt.Sprintf("otp_store_test_%d_%d", time.Now().UnixNano(), tableSeq.Add(1))
+	c.Table = name
+	c.NotifyChannel = name
+	p, err := New(dbc, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := p.Stop(); err != nil {
+			t.Errorf("Stop: %v", err)
+		}
+		if _, err := dbc.GetDB().Exec("drop table if exists " + name); err != nil {
+			t.Errorf("dropping %s: %v", name, err)
+		}
+	})
+	return p
+}
+
+func subscribe(t *testing.T, p *Postgres) <-chan store.Event {
+	t.Helper()
+	events, err := p.Subscribe(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestConformance(t *testing.T) {
+	dbc := dbtest.Connect(t)
+	storetest.Run(t, func(t *testing.T) storetest.Backend {
+		p := newTestStore(t, dbc, Conf{})
+		// Postgres uses its own clock, so the expiry tests sleep.
+		return storetest.Backend{Store: p, Events: subscribe(t, p)}
+	})
+}
+
+func TestSweeper(t *testing.T) {
+	dbc := dbtest.Connect(t)
+	p := newTestStore(t, dbc, Conf{SweepInterval: 100 * time.Millisecond})
+	ctx := context.Background()
+	if _, err := p.Set(ctx, "ns", "expired", models.OTP{OTP: "123456", TTL: 200 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Set(ctx, "ns", "live", models.OTP{OTP: "123456", TTL: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		ids = nil
+		if err := p.db.Select(&ids, "select id from "+p.conf.Table+" order by id"); err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) == 1 {
+			break
+		}
+	}
+	if len(ids) != 1 || ids[0] != "live" {
+		t.Errorf("rows after sweeping = %v, want [live]", ids)
+	}
+}
+
+func TestNotifyFanOut(t *testing.T) {
+	dbc := dbtest.Connect(t)
+	p := newTestStore(t, dbc, Conf{})
+	subs := []<-chan store.Event{subscribe(t, p), subscribe(t, p), subscribe(t, p)}
+	p.Unsubscribe(subs[2])
+
+	if _, err := p.Set(context.Background(), "ns", "id", models.OTP{OTP: "123456", TTL: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(context.Background(), "ns", "id"); err != nil {
+		t.Fatal(err)
+	}
+	for i, events := range subs[:2] {
+		select {
+		case e := <-events:
+			if e.Type != store.EventClose || e.Namespace != "ns" || e.ID != "id" {
+				t.Errorf("subscriber %d got %+v", i, e)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("subscriber %d got no event", i)
+		}
+	}
+	if _, ok := <-subs[2]; ok {
+		t.Error("the channel of an unsubscribed subscriber is still open")
+	}
+
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	for i, events := range subs[:2] {
+		if _, ok := <-events; ok {
+			t.Errorf("subscriber %d is still open after Stop", i)
+		}
+	}
+	if _, err := p.Subscribe(1); err == nil {
+		t.Error("Subscribe succeeded after Stop")
+	}
+}
@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"os"
+)
+
+// Deployment modes of Redis.
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// TLSConf contains the TLS configuration fields. TLS is off unless
+// Enabled is set.
+type TLSConf struct {
+	Enabled bool `json:"enabled"`
+	// ServerName is verified against the certificate of the server.
+	// Defaults to the host being dialed.
+	ServerName string `json:"server_name"`
+	// CAFile is a PEM file of the CAs trusted in addition to the system
+	// pool.
+	CAFile string `json:"ca_file"`
+	// CertFile and KeyFile are the PEM files of the client certificate
+	// for mutual TLS.
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// NewClient returns a go-redis client for the connection fields of the
+// config: a *redis.Client in standalone mode, a failover client in
+// sentinel mode and a *redis.ClusterClient in cluster mode.
+func NewClient(c Conf) (redis.UniversalClient, error) {
+	tlsConfig, err := c.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := c.Addrs
+	if len(addrs) == 0 && c.Host != "" {
+		addrs = []string{fmt.Sprintf("%s:%d", c.Host, c.Port)}
+	}
+
+	switch c.Mode {
+	case "", ModeStandalone:
+		addr := fmt.Sprintf("%s:%d", c.Host, c.Port)
+		if c.Host == "" && len(addrs) > 0 {
+			addr = addrs[0]
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:         addr,
+			Username:     c.Username,
+			Password:     c.Password,
+			DB:           c.DB,
+			PoolSize:     c.MaxActive,
+			MaxIdleConns: c.MaxIdle,
+			DialTimeout:  c.Timeout,
+			WriteTimeout: c.Timeout,
+			ReadTimeout:  c.Timeout,
+			TLSConfig:    tlsConfig,
+		}), nil
+
+	case ModeSentinel:
+		if c.MasterName == "" {
+			return nil, errors.New("redis: sentinel mode requires a master name")
+		}
+		if len(addrs) == 0 {
+			return nil, errors.New("redis: sentinel mode requires the sentinel addresses")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       c.MasterName,
+			SentinelAddrs:    addrs,
+			SentinelUsername: c.SentinelUsername,
+			SentinelPassword: c.SentinelPassword,
+			Username:         c.Username,
+			Password:         c.Password,
+			DB:               c.DB,
+			PoolSize:         c.MaxActive,
+			MaxIdleConns:     c.MaxIdle,
+			DialTimeout:      c.Timeout,
+			WriteTimeout:     c.Timeout,
+			ReadTimeout:      c.Timeout,
+			TLSConfig:        tlsConfig,
+		}), nil
+
+	case ModeCluster:
+		if len(addrs) == 0 {
+			return nil, errors.New("redis: cluster mode requires the node addresses")
+		}
+		if c.DB != 0 {
+			return nil, errors.New("redis: cluster mode supports only DB 0")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        addrs,
+			Username:     c.Username,
+			Password:     c.Password,
+			PoolSize:     c.MaxActive,
+			MaxIdleConns: c.MaxIdle,
+			DialTimeout:  c.Timeout,
+			WriteTimeout: c.Timeout,
+			ReadTimeout:  c.Timeout,
+			TLSConfig:    tlsConfig,
+		}), nil
+	}
+	return nil, fmt.Errorf("redis: unknown mode %q", c.Mode)
+}
+
+// config returns the tls.Config of the settings or nil when TLS is off.
+func (t TLSConf) config() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis: reading the CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("redis: no certificate found in the CA file")
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis: loading the client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
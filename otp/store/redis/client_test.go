@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/store/storetest"
+	"testing"
+	"time"
+)
+
+var testOTP = models.OTP{OTP: "123456", TTL: time.Minute, MaxAttempts: 3}
+
+func TestNewClientStandaloneAddrs(t *testing.T) {
+	mr, _ := newTestRedis(t)
+	client, err := NewClient(Conf{Addrs: []string{mr.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	if got := client.(*redis.Client).Options().Addr; got != mr.Addr() {
+		t.Errorf("Addr = %q, want %q", got, mr.Addr())
+	}
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDBAndPoolSize(t *testing.T) {
+	mr, c := newTestRedis(t)
+	c.DB = 3
+	c.MaxActive = 7
+	c.MaxIdle = 2
+	r, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Client().Close() })
+
+	opts := r.Client().(*redis.Client).Options()
+	if opts.DB != 3 || opts.PoolSize != 7 || opts.MaxIdleConns != 2 {
+		t.Errorf("got DB %d, pool size %d and max idle %d, want 3, 7 and 2", opts.DB, opts.PoolSize, opts.MaxIdleConns)
+	}
+
+	if _, err := r.Set(context.Background(), "ns", "id", testOTP); err != nil {
+		t.Fatal(err)
+	}
+	mr.Select(0)
+	if mr.Exists("OTP:ns:id") {
+		t.Error("the OTP was stored in DB 0")
+	}
+	mr.Select(3)
+	if !mr.Exists("OTP:ns:id") {
+		t.Error("the OTP was not stored in DB 3")
+	}
+}
+
+func TestCluster(t *testing.T) {
+	mr, _ := newTestRedis(t)
+	r, err := New(Conf{Mode: ModeCluster, Addrs: []string{mr.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Client().Close() })
+
+	if _, ok := r.Client().(*redis.ClusterClient); !ok {
+		t.Fatalf("got a %T, want a *redis.ClusterClient", r.Client())
+	}
+	if _, err := r.Set(context.Background(), "ns", "id", testOTP); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("OTP:{ns:id}") {
+		t.Errorf("the key is not hash tagged, got %v", mr.Keys())
+	}
+
+	storetest.Run(t, func(t *testing.T) storetest.Backend {
+		mr.FlushAll()
+		return storetest.Backend{Store: r, Advance: mr.FastForward}
+	})
+}
+
+func TestNewClientValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Conf
+	}{
+		{"unknown mode", Conf{Mode: "replica", Host: "localhost"}},
+		{"sentinel without master", Conf{Mode: ModeSentinel, Addrs: []string{"localhost:26379"}}},
+		{"sentinel without addresses", Conf{Mode: ModeSentinel, MasterName: "mymaster"}},
+		{"cluster without addresses", Conf{Mode: ModeCluster}},
+		{"cluster with a DB", Conf{Mode: ModeCluster, Addrs: []string{"localhost:7000"}, DB: 1}},
+		{"missing CA file", Conf{Host: "localhost", TLS: TLSConf{Enabled: true, CAFile: "does-not-exist.pem"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if client, err := NewClient(tt.conf); err == nil {
+				client.Close()
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
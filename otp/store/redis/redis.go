@@ -12,68 +12,81 @@ import (
 
 // Redis implements a Redis Store.
 type Redis struct {
-	client *redis.Client
+	client redis.UniversalClient
 	conf   Conf
+	// hashTags wraps the namespace and ID of the keys in a hash tag so
+	// that every key of an OTP maps to the same cluster slot.
+	hashTags bool
 }
 
-var (
-	ctx = context.Background()
-)
-
 // Conf contains Redis configuration fields.
 type Conf struct {
-	Host      string        `json:"host"`
-	Port      int           `json:"port"`
-	Username  string        `json:"username"`
-	Password  string        `json:"password"`
-	DB        int           `json:"db"`
-	MaxActive int           `json:"max_active"`
-	MaxIdle   int           `json:"max_idle"`
-	Timeout   time.Duration `json:"timeout"`
-	KeyPrefix string        `json:"key_prefix"`
+	// Mode is one of ModeStandalone (default), ModeSentinel or ModeCluster.
+	Mode string `json:"mode"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// Addrs are the addresses of the sentinels in sentinel mode and the
+	// seed nodes in cluster mode. Host and Port are used when empty. In
+	// standalone mode the first one is used when Host is empty.
+	Addrs    []string `json:"addrs"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	// DB is the database selected after connecting. It has to be 0 in
+	// cluster mode.
+	DB int `json:"db"`
+	// MaxActive is the maximum number of connections, per node in
+	// cluster mode. Defaults to 10 per CPU.
+	MaxActive int `json:"max_active"`
+	// MaxIdle is the maximum number of idle connections kept open.
+	MaxIdle int           `json:"max_idle"`
+	Timeout time.Duration `json:"timeout"`
+	// MasterName is the name of the master monitored by the sentinels.
+	MasterName       string  `json:"master_name"`
+	SentinelUsername string  `json:"sentinel_username"`
+	SentinelPassword string  `json:"sentinel_password"`
+	TLS              TLSConf `json:"tls"`
+	KeyPrefix        string  `json:"key_prefix"`
 	// If this is set, 'check' and 'close' events will be PUBLISHed to
 	// to this Redis key (Redis PubSub).
 	PublishKey string `json:"publish_key"`
 }
 
-type event struct {
-	Type      string          `json:"type"`
-	Namespace string          `json:"namespace"`
-	ID        string          `json:"id"`
-	Data      json.RawMessage `json:"data"`
+// New returns a Redis implementation of store with its own client.
+func New(c Conf) (*Redis, error) {
+	client, err := NewClient(c)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithClient(client, c), nil
 }
 
-// New returns a Redis implementation of store.
-func New(c Conf) *Redis {
+// NewWithClient returns a Redis implementation of store on top of an
+// existing client, for instance one shared with the application cache.
+// Only the mode, the key prefix and the publish key of the config are
+// used. Keys are hash tagged when the client is a cluster client.
+func NewWithClient(client redis.UniversalClient, c Conf) *Redis {
 	if c.KeyPrefix == "" {
 		c.KeyPrefix = "OTP"
 	}
-
-	client := redis.NewClient(&redis.Options{
-		Addr:         fmt.Sprintf("%s:%d", c.Host, c.Port),
-		Username:     c.Username,
-		Password:     c.Password,
-		DialTimeout:  c.Timeout,
-		WriteTimeout: c.Timeout,
-		ReadTimeout:  c.Timeout,
-	})
+	_, cluster := client.(*redis.ClusterClient)
 
 	return &Redis{
-		conf:   c,
-		client: client,
+		conf:     c,
+		client:   client,
+		hashTags: cluster || c.Mode == ModeCluster,
 	}
 }
 
 // Ping checks if Redis server is reachable
-func (r *Redis) Ping() error {
+func (r *Redis) Ping(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
 }
 
 // Check checks the attempt count and TTL duration against an ID.
 // Passing count=true increments the attempt counter.
-func (r *Redis) Check(namespace, id string, counter bool) (models.OTP, error) {
+func (r *Redis) Check(ctx context.Context, namespace, id string, counter bool) (models.OTP, error) {
 	// Retrieve the OTP information.
-	out, err := r.get(namespace, id)
+	out, err := r.get(ctx, namespace, id)
 	if err != nil {
 		return out, err
 	}
@@ -99,8 +112,8 @@ func (r *Redis) Check(namespace, id string, counter bool) (models.OTP, error) {
 	// If there's a configured PublishKey, publish the event.
 	if r.conf.PublishKey != "" {
 		b, _ := json.Marshal(out)
-		e, _ := json.Marshal(event{
-			Type:      "check",
+		e, _ := json.Marshal(store.Event{
+			Type:      store.EventCheck,
 			Namespace: namespace,
 			ID:        id,
 			Data:      json.RawMessage(b),
@@ -114,11 +127,10 @@ func (r *Redis) Check(namespace, id string, counter bool) (models.OTP, error) {
 	return out, nil
 }
 
-func (r *Redis) Set(namespace, id string, otp models.OTP) (models.OTP, error) {
+func (r *Redis) Set(ctx context.Context, namespace, id string, otp models.OTP) (models.OTP, error) {
 	// Set the OTP value.
 	key := r.makeKey(namespace, id)
 	exp := otp.TTL.Milliseconds()
-	fmt.Println(key)
 	// Create a transaction to execute commands atomically.
 	txf := func(tx *redis.Tx) error {
 		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
@@ -161,7 +173,7 @@ func (r *Redis) Set(namespace, id string, otp models.OTP) (models.OTP, error) {
 }
 
 // SetAddress sets (updates) the address on an existing OTP.
-func (r *Redis) SetAddress(namespace, id, address string) error {
+func (r *Redis) SetAddress(ctx context.Context, namespace, id, address string) error {
 	// Set the OTP value.
 	key := r.makeKey(namespace, id)
 
@@ -174,7 +186,7 @@ func (r *Redis) SetAddress(namespace, id, address string) error {
 
 // Close closes an OTP and marks it as done (verified).
 // After this, the OTP has to expire after a TTL or be deleted.
-func (r *Redis) Close(namespace, id string) error {
+func (r *Redis) Close(ctx context.Context, namespace, id string) error {
 	// Set the OTP as closed.
 	if err := r.client.HSet(ctx, r.makeKey(namespace, id), "closed", true).Err(); err != nil {
 		return err
@@ -182,8 +194,8 @@ func (r *Redis) Close(namespace, id string) error {
 
 	// Publish?
 	if r.conf.PublishKey != "" {
-		e, _ := json.Marshal(event{
-			Type:      "close",
+		e, _ := json.Marshal(store.Event{
+			Type:      store.EventClose,
 			Namespace: namespace,
 			ID:        id,
 			Data:      json.RawMessage([]byte(`null`)),
@@ -197,20 +209,25 @@ func (r *Redis) Close(namespace, id string) error {
 }
 
 // Delete deletes the OTP saved against a given ID.
-func (r *Redis) Delete(namespace, id string) error {
+func (r *Redis) Delete(ctx context.Context, namespace, id string) error {
 	if err := r.client.Del(ctx, r.makeKey(namespace, id)).Err(); err != nil {
 		return err
 	}
 	return nil
 }
 
-// makeKey makes the Redis key for the OTP.
+// makeKey makes the Redis key for the OTP. In cluster mode the namespace
+// and ID are a hash tag, e.g. OTP:{ns:id}, so that the keys of an OTP
+// stay on one slot.
 func (r *Redis) makeKey(namespace, id string) string {
+	if r.hashTags {
+		return fmt.Sprintf("%s:{%s:%s}", r.conf.KeyPrefix, namespace, id)
+	}
 	return fmt.Sprintf("%s:%s:%s", r.conf.KeyPrefix, namespace, id)
 }
 
 // get retrieves the OTP information from Redis based on the namespace and ID.
-func (r *Redis) get(namespace, id string) (models.OTP, error) {
+func (r *Redis) get(ctx context.Context, namespace, id string) (models.OTP, error) {
 	key := r.makeKey(namespace, id)
 	out := models.OTP{
 		Namespace: namespace,
@@ -238,6 +255,7 @@ func (r *Redis) get(namespace, id string) (models.OTP, error) {
 	return out, nil
 }
 
-func (r *Redis) Client() *redis.Client {
+// Client returns the underlying go-redis client.
+func (r *Redis) Client() redis.UniversalClient {
 	return r.client
 }
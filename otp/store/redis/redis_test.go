@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/suhailgupta03/thunderbyte/otp/store"
+	"github.com/suhailgupta03/thunderbyte/otp/store/storetest"
+	"strconv"
+	"testing"
+)
+
+const publishKey = "otp-events"
+
+// newTestRedis starts a miniredis server and returns it with the
+// config of a store connected to it.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, Conf) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mr, Conf{Host: mr.Host(), Port: port}
+}
+
+// subscribe decodes the events published on key into a channel.
+func subscribe(t *testing.T, r *Redis, key string) <-chan store.Event {
+	t.Helper()
+	sub := r.Client().Subscribe(context.Background(), key)
+	if _, err := sub.Receive(context.Background()); err != nil {
+		t.Fatalf("subscribing to %s: %v", key, err)
+	}
+	t.Cleanup(func() { sub.Close() })
+
+	events := make(chan store.Event, 16)
+	go func() {
+		for msg := range sub.Channel() {
+			var e store.Event
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				continue
+			}
+			events <- e
+		}
+	}()
+	return events
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Backend {
+		mr, c := newTestRedis(t)
+		c.PublishKey = publishKey
+		r, err := New(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Client().Close() })
+		return storetest.Backend{
+			Store:   r,
+			Advance: mr.FastForward,
+			Events:  subscribe(t, r, publishKey),
+		}
+	})
+}
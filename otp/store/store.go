@@ -1,8 +1,9 @@
 package store
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
-	"github.com/redis/go-redis/v9"
 	"github.com/suhailgupta03/thunderbyte/otp/models"
 )
 
@@ -10,28 +11,43 @@ import (
 // does not exist.
 var ErrNotExist = errors.New("the OTP does not exist")
 
-// Store represents a storage backend where OTP data is stored.
+// Event types published by stores that support events.
+const (
+	EventCheck = "check"
+	EventClose = "close"
+)
+
+// Event is published when an OTP is checked or closed. For a check
+// event Data holds the OTP encoded as JSON.
+type Event struct {
+	Type      string          `json:"type"`
+	Namespace string          `json:"namespace"`
+	ID        string          `json:"id"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Store represents a storage backend where OTP data is stored. Every
+// method takes the context of the request so that cancellation and
+// deadlines reach the backend.
 type Store interface {
 	// Set sets an OTP against an ID. Every Set() increments the attempts
 	// count against the ID that was initially set.
-	Set(namespace, id string, otp models.OTP) (models.OTP, error)
+	Set(ctx context.Context, namespace, id string, otp models.OTP) (models.OTP, error)
 
 	// SetAddress sets (updates) the address on an existing OTP.
-	SetAddress(namespace, id, address string) error
+	SetAddress(ctx context.Context, namespace, id, address string) error
 
 	// Check checks the attempt count and TTL duration against an ID.
 	// Passing counter=true increments the attempt counter.
-	Check(namespace, id string, counter bool) (models.OTP, error)
+	Check(ctx context.Context, namespace, id string, counter bool) (models.OTP, error)
 
 	// Close closes an OTP and marks it as done (verified).
 	// After this, the OTP has to expire after a TTL or be deleted.
-	Close(namespace, id string) error
+	Close(ctx context.Context, namespace, id string) error
 
 	// Delete deletes the OTP saved against a given ID.
-	Delete(namespace, id string) error
+	Delete(ctx context.Context, namespace, id string) error
 
 	// Ping checks if store is reachable
-	Ping() error
-
-	Client() *redis.Client
+	Ping(ctx context.Context) error
 }
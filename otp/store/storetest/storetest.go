@@ -0,0 +1,354 @@
+// Package storetest is a conformance suite for store.Store
+// implementations. Every store is expected to pass it so that the otp
+// package behaves the same whatever backend it runs on:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) storetest.Backend {
+//			return storetest.Backend{Store: memory.New(memory.Conf{})}
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/suhailgupta03/thunderbyte/otp/models"
+	"github.com/suhailgupta03/thunderbyte/otp/store"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventTimeout is how long the suite waits for an event to be received.
+const eventTimeout = 2 * time.Second
+
+// Backend is a store under test.
+type Backend struct {
+	// Store is the store under test. It must be empty.
+	Store store.Store
+	// Advance moves the clock of the store forward. When nil the suite
+	// sleeps instead, which makes the expiry tests take a few seconds.
+	Advance func(d time.Duration)
+	// Events receives the events published by the store. When nil the
+	// event tests are skipped.
+	Events <-chan store.Event
+}
+
+// Run runs the conformance suite. newBackend is called once per test
+// and must return a backend with an empty store.
+func Run(t *testing.T, newBackend func(t *testing.T) Backend) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, b Backend)
+	}{
+		{"CheckMissing", testCheckMissing},
+		{"SetAndCheck", testSetAndCheck},
+		{"SetIncrementsAttempts", testSetIncrementsAttempts},
+		{"CheckCounter", testCheckCounter},
+		{"SetAddress", testSetAddress},
+		{"Close", testClose},
+		{"Delete", testDelete},
+		{"Namespaces", testNamespaces},
+		{"Expiry", testExpiry},
+		{"SetResetsTTL", testSetResetsTTL},
+		{"ConcurrentCheck", testConcurrentCheck},
+		{"Events", testEvents},
+		{"CanceledContext", testCanceledContext},
+		{"Ping", testPing},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newBackend(t))
+		})
+	}
+}
+
+func newOTP(ttl time.Duration) models.OTP {
+	return models.OTP{
+		To:          "user@example.com",
+		ChannelDesc: "We've sent a code to your e-mail",
+		AddressDesc: "Enter your e-mail",
+		Extra:       json.RawMessage(`{"k":"v"}`),
+		Provider:    "smtp",
+		OTP:         "123456",
+		MaxAttempts: 5,
+		TTL:         ttl,
+	}
+}
+
+func advance(b Backend, d time.Duration) {
+	if b.Advance != nil {
+		b.Advance(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+func mustSet(t *testing.T, b Backend, namespace, id string, otp models.OTP) models.OTP {
+	t.Helper()
+	out, err := b.Store.Set(context.Background(), namespace, id, otp)
+	if err != nil {
+		t.Fatalf("Set(%q, %q): %v", namespace, id, err)
+	}
+	return out
+}
+
+func mustCheck(t *testing.T, b Backend, namespace, id string, counter bool) models.OTP {
+	t.Helper()
+	out, err := b.Store.Check(context.Background(), namespace, id, counter)
+	if err != nil {
+		t.Fatalf("Check(%q, %q, %v): %v", namespace, id, counter, err)
+	}
+	return out
+}
+
+func assertMissing(t *testing.T, b Backend, namespace, id string) {
+	t.Helper()
+	if _, err := b.Store.Check(context.Background(), namespace, id, false); !errors.Is(err, store.ErrNotExist) {
+		t.Fatalf("Check(%q, %q) error = %v, want %v", namespace, id, err, store.ErrNotExist)
+	}
+}
+
+func testCheckMissing(t *testing.T, b Backend) {
+	assertMissing(t, b, "ns", "missing")
+	if _, err := b.Store.Check(context.Background(), "ns", "missing", true); !errors.Is(err, store.ErrNotExist) {
+		t.Fatalf("Check with counter error = %v, want %v", err, store.ErrNotExist)
+	}
+}
+
+func testSetAndCheck(t *testing.T, b Backend) {
+	in := newOTP(time.Minute)
+	set := mustSet(t, b, "ns", "id", in)
+	if set.Attempts != 1 {
+		t.Errorf("Set attempts = %d, want 1", set.Attempts)
+	}
+	if set.Namespace != "ns" || set.ID != "id" {
+		t.Errorf("Set namespace, id = %q, %q, want %q, %q", set.Namespace, set.ID, "ns", "id")
+	}
+
+	out := mustCheck(t, b, "ns", "id", false)
+	if out.Namespace != "ns" || out.ID != "id" {
+		t.Errorf("namespace, id = %q, %q, want %q, %q", out.Namespace, out.ID, "ns", "id")
+	}
+	if out.OTP != in.OTP || out.To != in.To || out.Provider != in.Provider {
+		t.Errorf("otp, to, provider = %q, %q, %q, want %q, %q, %q",
+			out.OTP, out.To, out.Provider, in.OTP, in.To, in.Provider)
+	}
+	if out.ChannelDesc != in.ChannelDesc || out.AddressDesc != in.AddressDesc {
+		t.Errorf("descriptions = %q, %q, want %q, %q",
+			out.ChannelDesc, out.AddressDesc, in.ChannelDesc, in.AddressDesc)
+	}
+	if string(out.Extra) != string(in.Extra) {
+		t.Errorf("extra = %s, want %s", out.Extra, in.Extra)
+	}
+	if out.MaxAttempts != in.MaxAttempts {
+		t.Errorf("max attempts = %d, want %d", out.MaxAttempts, in.MaxAttempts)
+	}
+	if out.Attempts != 1 {
+		t.Errorf("attempts = %d, want 1", out.Attempts)
+	}
+	if out.Closed {
+		t.Error("closed = true, want false")
+	}
+	if out.TTL <= 0 || out.TTL > in.TTL {
+		t.Errorf("TTL = %v, want in (0, %v]", out.TTL, in.TTL)
+	}
+}
+
+func testSetIncrementsAttempts(t *testing.T, b Backend) {
+	mustSet(t, b, "ns", "id", newOTP(time.Minute))
+	next := newOTP(time.Minute)
+	next.OTP = "654321"
+	set := mustSet(t, b, "ns", "id", next)
+	if set.Attempts != 2 {
+		t.Errorf("Set attempts = %d, want 2", set.Attempts)
+	}
+	out := mustCheck(t, b, "ns", "id", false)
+	if out.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", out.Attempts)
+	}
+	if out.OTP != next.OTP {
+		t.Errorf("otp = %q, want %q", out.OTP, next.OTP)
+	}
+}
+
+func testCheckCounter(t *testing.T, b Backend) {
+	mustSet(t, b, "ns", "id", newOTP(time.Minute))
+	if out := mustCheck(t, b, "ns", "id", false); out.Attempts != 1 {
+		t.Errorf("attempts without counter = %d, want 1", out.Attempts)
+	}
+	if out := mustCheck(t, b, "ns", "id", true); out.Attempts != 2 {
+		t.Errorf("attempts with counter = %d, want 2", out.Attempts)
+	}
+	if out := mustCheck(t, b, "ns", "id", true); out.Attempts != 3 {
+		t.Errorf("attempts with counter = %d, want 3", out.Attempts)
+	}
+	if out := mustCheck(t, b, "ns", "id", false); out.Attempts != 3 {
+		t.Errorf("attempts without counter = %d, want 3", out.Attempts)
+	}
+}
+
+func testSetAddress(t *testing.T, b Backend) {
+	mustSet(t, b, "ns", "id", newOTP(time.Minute))
+	if err := b.Store.SetAddress(context.Background(), "ns", "id", "other@example.com"); err != nil {
+		t.Fatalf("SetAddress: %v", err)
+	}
+	if out := mustCheck(t, b, "ns", "id", false); out.To != "other@example.com" {
+		t.Errorf("to = %q, want %q", out.To, "other@example.com")
+	}
+}
+
+func testClose(t *testing.T, b Backend) {
+	mustSet(t, b, "ns", "id", newOTP(time.Minute))
+	if err := b.Store.Close(context.Background(), "ns", "id"); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if out := mustCheck(t, b, "ns", "id", false); !out.Closed {
+		t.Error("closed = false after Close, want true")
+	}
+
+	// Setting a closed OTP opens it again.
+	mustSet(t, b, "ns", "id", newOTP(time.Minute))
+	if out := mustCheck(t, b, "ns", "id", false); out.Closed {
+		t.Error("closed = true after Set, want false")
+	}
+}
+
+func testDelete(t *testing.T, b Backend) {
+	mustSet(t, b, "ns", "id", newOTP(time.Minute))
+	if err := b.Store.Delete(context.Background(), "ns", "id"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertMissing(t, b, "ns", "id")
+	if err := b.Store.Delete(context.Background(), "ns", "id"); err != nil {
+		t.Fatalf("Delete of a missing OTP: %v", err)
+	}
+
+	// Attempts start over once an OTP is deleted.
+	if set := mustSet(t, b, "ns", "id", newOTP(time.Minute)); set.Attempts != 1 {
+		t.Errorf("attempts after Delete = %d, want 1", set.Attempts)
+	}
+}
+
+func testNamespaces(t *testing.T, b Backend) {
+	a := newOTP(time.Minute)
+	a.OTP = "111111"
+	mustSet(t, b, "a", "id", a)
+	assertMissing(t, b, "b", "id")
+
+	c := newOTP(time.Minute)
+	c.OTP = "222222"
+	mustSet(t, b, "b", "id", c)
+	if out := mustCheck(t, b, "a", "id", false); out.OTP != a.OTP {
+		t.Errorf("otp in namespace a = %q, want %q", out.OTP, a.OTP)
+	}
+	if out := mustCheck(t, b, "b", "id", false); out.OTP != c.OTP {
+		t.Errorf("otp in namespace b = %q, want %q", out.OTP, c.OTP)
+	}
+}
+
+func testExpiry(t *testing.T, b Backend) {
+	mustSet(t, b, "ns", "id", newOTP(2*time.Second))
+	advance(b, 3*time.Second)
+	assertMissing(t, b, "ns", "id")
+
+	// Attempts of an expired OTP are not carried over.
+	if set := mustSet(t, b, "ns", "id", newOTP(time.Minute)); set.Attempts != 1 {
+		t.Errorf("attempts after expiry = %d, want 1", set.Attempts)
+	}
+}
+
+func testSetResetsTTL(t *testing.T, b Backend) {
+	mustSet(t, b, "ns", "id", newOTP(2*time.Second))
+	advance(b, time.Second)
+	mustSet(t, b, "ns", "id", newOTP(2*time.Second))
+	advance(b, 1500*time.Millisecond)
+	if out := mustCheck(t, b, "ns", "id", false); out.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", out.Attempts)
+	}
+}
+
+func testConcurrentCheck(t *testing.T, b Backend) {
+	const n = 50
+	mustSet(t, b, "ns", "id", newOTP(time.Minute))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b.Store.Check(context.Background(), "ns", "id", true); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent Check: %v", err)
+	}
+	if out := mustCheck(t, b, "ns", "id", false); out.Attempts != n+1 {
+		t.Errorf("attempts = %d, want %d", out.Attempts, n+1)
+	}
+}
+
+func testEvents(t *testing.T, b Backend) {
+	if b.Events == nil {
+		t.Skip("the store does not publish events")
+	}
+	mustSet(t, b, "ns", "id", newOTP(time.Minute))
+	mustCheck(t, b, "ns", "id", true)
+
+	e := nextEvent(t, b.Events)
+	if e.Type != store.EventCheck || e.Namespace != "ns" || e.ID != "id" {
+		t.Fatalf("event = %s %s:%s, want %s ns:id", e.Type, e.Namespace, e.ID, store.EventCheck)
+	}
+	var otp models.OTP
+	if err := json.Unmarshal(e.Data, &otp); err != nil {
+		t.Fatalf("decoding check event data: %v", err)
+	}
+	if otp.Attempts != 2 {
+		t.Errorf("check event attempts = %d, want 2", otp.Attempts)
+	}
+
+	if err := b.Store.Close(context.Background(), "ns", "id"); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	e = nextEvent(t, b.Events)
+	if e.Type != store.EventClose || e.Namespace != "ns" || e.ID != "id" {
+		t.Fatalf("event = %s %s:%s, want %s ns:id", e.Type, e.Namespace, e.ID, store.EventClose)
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan store.Event) store.Event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("event channel closed")
+		}
+		return e
+	case <-time.After(eventTimeout):
+		t.Fatal("timed out waiting for an event")
+	}
+	return store.Event{}
+}
+
+func testCanceledContext(t *testing.T, b Backend) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.Store.Set(ctx, "ns", "id", newOTP(time.Minute)); err == nil {
+		t.Fatal("Set with a canceled context succeeded")
+	}
+	if _, err := b.Store.Check(ctx, "ns", "id", true); err == nil || errors.Is(err, store.ErrNotExist) {
+		t.Fatalf("Check with a canceled context error = %v, want the context error", err)
+	}
+}
+
+func testPing(t *testing.T, b Backend) {
+	if err := b.Store.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
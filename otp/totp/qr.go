@@ -0,0 +1,14 @@
+package totp
+
+import "github.com/skip2/go-qrcode"
+
+const defaultQRSize = 256
+
+// QRCodePNG renders the URI as a PNG QR code of size x size pixels.
+// A size of 0 gives 256 pixels.
+func QRCodePNG(uri string, size int) ([]byte, error) {
+	if size == 0 {
+		size = defaultQRSize
+	}
+	return qrcode.Encode(uri, qrcode.Medium, size)
+}
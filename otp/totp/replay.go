@@ -0,0 +1,55 @@
+package totp
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// ReplayCache remembers the last step used per key so that a code is
+// accepted only once, even within the skew window.
+type ReplayCache interface {
+	// Use records step for key. It returns false if step, or a later one,
+	// was already used.
+	Use(ctx context.Context, key string, step int64, ttl time.Duration) (bool, error)
+}
+
+// useStep sets the key to the step only when it is greater than the
+// stored one.
+var useStep = redis.NewScript(`
+local last = tonumber(redis.call('GET', KEYS[1]) or '-1')
+if tonumber(ARGV[1]) <= last then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// RedisReplayCache is a ReplayCache backed by Redis.
+type RedisReplayCache struct {
+	client    redis.Scripter
+	keyPrefix string
+}
+
+// NewRedisReplayCache returns a ReplayCache storing its keys under keyPrefix.
+func NewRedisReplayCache(client redis.Scripter, keyPrefix string) *RedisReplayCache {
+	if keyPrefix == "" {
+		keyPrefix = "TOTP:USED:"
+	}
+	return &RedisReplayCache{client: client, keyPrefix: keyPrefix}
+}
+
+func (r *RedisReplayCache) Use(ctx context.Context, key string, step int64, ttl time.Duration) (bool, error) {
+	ok, err := useStep.Run(ctx, r.client, []string{r.keyPrefix + key}, step, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return ok == 1, nil
+}
+
+// ReplayTTL is how long a used step has to be remembered for the config,
+// i.e. until it falls out of the skew window.
+func ReplayTTL(cfg Config) time.Duration {
+	cfg = cfg.withDefaults()
+	return time.Duration(2*cfg.Skew+1) * cfg.Period
+}
@@ -0,0 +1,69 @@
+package totp
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func TestRedisReplayCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	cache := NewRedisReplayCache(client, "")
+	ttl := ReplayTTL(Config{})
+
+	use := func(key string, step int64) bool {
+		t.Helper()
+		ok, err := cache.Use(context.Background(), key, step, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		step int64
+		want bool
+	}{
+		{"first use", "user:1", 100, true},
+		{"same step", "user:1", 100, false},
+		{"earlier step", "user:1", 99, false},
+		{"later step", "user:1", 101, true},
+		{"step before the later one", "user:1", 100, false},
+		{"other key", "user:2", 100, true},
+	}
+	for _, tt := range tests {
+		if got := use(tt.key, tt.step); got != tt.want {
+			t.Errorf("%s: Use(%s, %d) = %v, want %v", tt.name, tt.key, tt.step, got, tt.want)
+		}
+	}
+
+	if got := mr.TTL("TOTP:USED:user:1"); got != ttl {
+		t.Errorf("TTL = %v, want %v", got, ttl)
+	}
+	mr.FastForward(ttl + time.Second)
+	if !use("user:1", 50) {
+		t.Error("a step was still rejected after the replay TTL")
+	}
+}
+
+func TestReplayTTL(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		want time.Duration
+	}{
+		{Config{}, 90 * time.Second},
+		{Config{Skew: 2, Period: 60 * time.Second}, 5 * time.Minute},
+		{Config{Skew: -1}, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := ReplayTTL(tt.cfg); got != tt.want {
+			t.Errorf("ReplayTTL(%+v) = %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package totp implements HOTP (RFC 4226) and TOTP (RFC 6238) codes as
+// generated by authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Algorithm is the HMAC hash function of the codes.
+type Algorithm string
+
+const (
+	SHA1   Algorithm = "SHA1"
+	SHA256 Algorithm = "SHA256"
+	SHA512 Algorithm = "SHA512"
+)
+
+const (
+	defaultDigits     = 6
+	defaultPeriod     = 30 * time.Second
+	defaultSkew       = 1
+	defaultSecretSize = 20
+)
+
+// b32 is the unpadded base32 encoding used by otpauth:// URIs.
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Config holds the parameters shared by the server and the authenticator app.
+type Config struct {
+	// Issuer is shown by the authenticator app next to the account.
+	Issuer string
+	// Digits is the length of a code. Defaults to 6.
+	Digits int
+	// Period is the time step of TOTP codes. Defaults to 30 seconds.
+	Period time.Duration
+	// Algorithm defaults to SHA1, the only one supported by most apps.
+	Algorithm Algorithm
+	// Skew is the number of time steps accepted before and after the
+	// current one to tolerate clock drift. Defaults to 1.
+	Skew int
+}
+
+func (c Config) withDefaults() Config {
+	if c.Digits == 0 {
+		c.Digits = defaultDigits
+	}
+	if c.Period == 0 {
+		c.Period = defaultPeriod
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = SHA1
+	}
+	if c.Skew == 0 {
+		c.Skew = defaultSkew
+	}
+	// A negative skew disables the window.
+	if c.Skew < 0 {
+		c.Skew = 0
+	}
+	return c
+}
+
+func (a Algorithm) hash() (func() hash.Hash, error) {
+	switch a {
+	case SHA1:
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported TOTP algorithm '%s'", a)
+}
+
+// GenerateSecret returns a random base32 encoded secret of size bytes.
+// A size of 0 gives the 160 bits recommended by RFC 4226.
+func GenerateSecret(size int) (string, error) {
+	if size == 0 {
+		size = defaultSecretSize
+	}
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// decodeSecret accepts secrets with or without padding, spaces and in
+// lower case as typed by users.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	b, err := b32.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return b, nil
+}
+
+// HOTP returns the code of the counter.
+func HOTP(cfg Config, secret string, counter uint64) (string, error) {
+	cfg = cfg.withDefaults()
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	h, err := cfg.Algorithm.hash()
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(h, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < cfg.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", cfg.Digits, value%mod), nil
+}
+
+// Step returns the TOTP time step of t.
+func Step(cfg Config, t time.Time) int64 {
+	cfg = cfg.withDefaults()
+	return t.Unix() / int64(cfg.Period/time.Second)
+}
+
+// Code returns the TOTP code at t.
+func Code(cfg Config, secret string, t time.Time) (string, error) {
+	return HOTP(cfg, secret, uint64(Step(cfg, t)))
+}
+
+// Validate checks a TOTP code at t against the steps of the skew window.
+// It returns the matching step, to be passed to a ReplayCache so that the
+// code cannot be used twice.
+func Validate(cfg Config, secret, code string, t time.Time) (int64, bool, error) {
+	cfg = cfg.withDefaults()
+	if len(code) != cfg.Digits {
+		return 0, false, nil
+	}
+	current := Step(cfg, t)
+	for i := -cfg.Skew; i <= cfg.Skew; i++ {
+		step := current + int64(i)
+		if step < 0 {
+			continue
+		}
+		expected, err := HOTP(cfg, secret, uint64(step))
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// ValidateHOTP checks a HOTP code against the counter and the next
+// lookAhead counters. It returns the counter to be stored for the next
+// validation.
+func ValidateHOTP(cfg Config, secret, code string, counter uint64, lookAhead int) (uint64, bool, error) {
+	cfg = cfg.withDefaults()
+	if len(code) != cfg.Digits {
+		return counter, false, nil
+	}
+	for i := 0; i <= lookAhead; i++ {
+		expected, err := HOTP(cfg, secret, counter+uint64(i))
+		if err != nil {
+			return counter, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter + uint64(i) + 1, true, nil
+		}
+	}
+	return counter, false, nil
+}
+
+// URI returns the otpauth:// URI of a TOTP secret. Authenticator apps
+// enroll it by scanning its QR code, see QRCodePNG.
+func URI(cfg Config, account, secret string) string {
+	return buildURI("totp", cfg, account, secret, url.Values{
+		"period": {strconv.Itoa(int(cfg.withDefaults().Period / time.Second))},
+	})
+}
+
+// HOTPURI returns the otpauth:// URI of a HOTP secret starting at counter.
+func HOTPURI(cfg Config, account, secret string, counter uint64) string {
+	return buildURI("hotp", cfg, account, secret, url.Values{
+		"counter": {strconv.FormatUint(counter, 10)},
+	})
+}
+
+func buildURI(kind string, cfg Config, account, secret string, params url.Values) string {
+	cfg = cfg.withDefaults()
+	label := account
+	if cfg.Issuer != "" {
+		label = cfg.Issuer + ":" + account
+		params.Set("issuer", cfg.Issuer)
+	}
+	params.Set("secret", secret)
+	params.Set("algorithm", string(cfg.Algorithm))
+	params.Set("digits", strconv.Itoa(cfg.Digits))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     kind,
+		Path:     "/" + label,
+		RawQuery: params.Encode(),
+	}
+	return u.String()
+}
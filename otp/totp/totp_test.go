@@ -0,0 +1,133 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 4226 appendix D.
+func TestHOTPVectors(t *testing.T) {
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		got, err := HOTP(Config{}, secret, uint64(counter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != code {
+			t.Errorf("HOTP(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// RFC 6238 appendix B.
+func TestTOTPVectors(t *testing.T) {
+	seeds := map[Algorithm]string{
+		SHA1:   "12345678901234567890",
+		SHA256: "12345678901234567890123456789012",
+		SHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		unix int64
+		want map[Algorithm]string
+	}{
+		{59, map[Algorithm]string{SHA1: "94287082", SHA256: "46119246", SHA512: "90693936"}},
+		{1111111109, map[Algorithm]string{SHA1: "07081804", SHA256: "68084774", SHA512: "25091201"}},
+		{1111111111, map[Algorithm]string{SHA1: "14050471", SHA256: "67062674", SHA512: "99943326"}},
+		{1234567890, map[Algorithm]string{SHA1: "89005924", SHA256: "91819424", SHA512: "93441116"}},
+		{2000000000, map[Algorithm]string{SHA1: "69279037", SHA256: "90698825", SHA512: "38618901"}},
+		{20000000000, map[Algorithm]string{SHA1: "65353130", SHA256: "77737706", SHA512: "47863826"}},
+	}
+	for _, tt := range tests {
+		for alg, code := range tt.want {
+			cfg := Config{Digits: 8, Algorithm: alg}
+			secret := b32.EncodeToString([]byte(seeds[alg]))
+			at := time.Unix(tt.unix, 0)
+			got, err := Code(cfg, secret, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != code {
+				t.Errorf("%s at %d = %s, want %s", alg, tt.unix, got, code)
+			}
+			if _, ok, err := Validate(cfg, secret, code, at); err != nil || !ok {
+				t.Errorf("Validate(%s at %d) = %v, %v", alg, tt.unix, ok, err)
+			}
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	secret, err := GenerateSecret(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	current := Step(Config{}, now)
+
+	tests := []struct {
+		name   string
+		skew   int
+		offset int64
+		wantOK bool
+	}{
+		{"current step", 0, 0, true},
+		{"previous step", 1, -1, true},
+		{"next step", 1, 1, true},
+		{"outside the window", 1, -2, false},
+		{"wider window", 2, -2, true},
+		{"window disabled", -1, -1, false},
+		{"window disabled current step", -1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Skew: tt.skew}
+			code, err := HOTP(cfg, secret, uint64(current+tt.offset))
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok, err := Validate(cfg, secret, code, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("Validate() = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && step != current+tt.offset {
+				t.Errorf("step = %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(59, 0)
+	// 287082 is the code of the step of now, 520489 the one of step 9.
+	for _, code := range []string{"", "28708", "2870820", "520489"} {
+		if _, ok, _ := Validate(Config{}, secret, code, now); ok {
+			t.Errorf("Validate(%q) accepted a wrong code", code)
+		}
+	}
+	if _, _, err := Validate(Config{}, "not base32!", "123456", now); err == nil {
+		t.Error("Validate accepted an invalid secret")
+	}
+	if _, _, err := Validate(Config{Algorithm: "MD5"}, secret, "123456", now); err == nil {
+		t.Error("Validate accepted an unsupported algorithm")
+	}
+	// Secrets are accepted as typed by users.
+	typed := strings.ToLower(secret[:4] + " " + secret[4:])
+	if a, b := mustCode(t, secret, now), mustCode(t, typed, now); a != b {
+		t.Errorf("code of %q = %s, want %s", typed, b, a)
+	}
+}
+
+func mustCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := Code(Config{}, secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}